@@ -0,0 +1,95 @@
+package objstore
+
+import (
+	"context"
+	"io"
+)
+
+// Backend is the storage layer beneath Client. Client takes care of
+// encryption, compression and archiving, and hands the resulting byte
+// streams to a Backend for storage.
+//
+// Implementations must return ErrPathNotFound and ErrBucketNotFound from Get
+// and Stat for missing objects and buckets respectively. Once ctx is done,
+// operations in progress, including reads from returned objects, should fail.
+//
+// Metadata keys are given in canonical form, see http.CanonicalHeaderKey.
+type Backend interface {
+	// Put stores the data read from r as bucket/rPath, replacing any
+	// existing object.
+	Put(ctx context.Context, bucket, rPath string, r io.Reader, opts BackendPutOptions) error
+
+	// SetMeta replaces the metadata of the object bucket/rPath.
+	SetMeta(ctx context.Context, bucket, rPath string, meta map[string]string) error
+
+	// Get returns the object bucket/rPath along with its information,
+	// including metadata. The caller must close it.
+	Get(ctx context.Context, bucket, rPath string) (io.ReadCloser, FileInfo, error)
+
+	// GetRange returns length bytes of the object bucket/rPath starting at
+	// offset, or the rest of the object if length is negative. The range
+	// must lie within the object. The caller must close it.
+	GetRange(ctx context.Context, bucket, rPath string, offset, length int64) (io.ReadCloser, error)
+
+	// Stat returns information about the object bucket/rPath.
+	Stat(ctx context.Context, bucket, rPath string) (FileInfo, error)
+
+	// List returns the objects in bucket with the given prefix, sorted by
+	// name. Unless opts.Recursive is set, objects below the next delimiter
+	// after prefix are returned as a single entry with IsDir set, whose name
+	// is the common prefix including the delimiter. Only
+	// entries sorting after opts.StartAfter are returned, and at most
+	// opts.MaxKeys of them if set. If more entries follow, truncated is set.
+	List(ctx context.Context, bucket, prefix string, opts BackendListOptions) (l []FileInfo, truncated bool, err error)
+
+	// Delete removes the given objects. Missing objects aren't an error. If
+	// some objects can't be removed, a *MultiError is returned.
+	Delete(ctx context.Context, bucket string, rPaths ...string) error
+
+	// Copy copies srcBucket/srcPath to dstBucket/dstPath. If meta is nil,
+	// the metadata is copied too. Otherwise it's replaced by meta.
+	Copy(ctx context.Context, srcBucket, srcPath, dstBucket, dstPath string, meta map[string]string) error
+}
+
+// BackendPutOptions configures Backend.Put.
+type BackendPutOptions struct {
+	Meta     map[string]string // Metadata stored with the object.
+	PartSize int64             // Size of multipart upload parts, if used.
+
+	// Size is the size of the data, if known and not 0. If it's set and the
+	// reader is an io.ReaderAt, Concurrency parts are uploaded in parallel.
+	Size        int64
+	Concurrency int
+
+	// Trailer, if set, is called once the data has been uploaded, and returns
+	// metadata stored in addition to Meta, such as a checksum computed while
+	// uploading. It's stored with the data if the backend can, and otherwise
+	// set by updating the metadata once afterwards, which may copy the
+	// object. It's thus only used for data of unknown size.
+	Trailer func() (map[string]string, error)
+}
+
+// trailerMeta returns Meta along with the metadata returned by Trailer.
+func (opts BackendPutOptions) trailerMeta() (map[string]string, error) {
+	trailer, err := opts.Trailer()
+	if err != nil {
+		return nil, err
+	}
+
+	meta := map[string]string{}
+	for key, val := range opts.Meta {
+		meta[key] = val
+	}
+	for key, val := range trailer {
+		meta[key] = val
+	}
+	return meta, nil
+}
+
+// BackendListOptions configures Backend.List.
+type BackendListOptions struct {
+	Recursive  bool
+	Delimiter  string // Delimiter for non-recursive listings. Default: "/".
+	StartAfter string // Only list entries sorting after this name.
+	MaxKeys    int    // Maximum number of entries returned. 0: no limit.
+}
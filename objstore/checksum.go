@@ -0,0 +1,61 @@
+package objstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+)
+
+// seekableChecksum returns the checksum of the data remaining in r if r is an
+// io.Seeker, leaving r at its current position. Otherwise, it returns an
+// empty string.
+func seekableChecksum(r io.Reader) (string, error) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return "", nil
+	}
+
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Not actually seekable, e.g. a pipe.
+		return "", nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		log.Printf("Failed to compute checksum: %v", err)
+		return "", err
+	}
+
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("Failed to rewind after computing checksum: %v", err)
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ----------------------------------------------------------------------------
+
+// checksumReader computes the checksum of the data read from r, and returns
+// ErrChecksumMismatch instead of io.EOF if it doesn't match sum.
+type checksumReader struct {
+	r   io.Reader
+	h   hash.Hash
+	sum string
+}
+
+func newChecksumReader(r io.Reader, sum string) *checksumReader {
+	return &checksumReader{r: r, h: sha256.New(), sum: sum}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.h.Sum(nil)) != r.sum {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,113 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+// setMetaBackend counts the calls to SetMeta.
+type setMetaBackend struct {
+	Backend
+	Calls int
+}
+
+func (be *setMetaBackend) SetMeta(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	meta map[string]string,
+) error {
+	be.Calls++
+	return be.Backend.SetMeta(ctx, bucket, rPath, meta)
+}
+
+func TestClientChecksum(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	be := &setMetaBackend{Backend: cl.Backend}
+	cl.Backend = be
+
+	data := []byte("Some data.")
+	h := sha256.Sum256(data)
+	sum := hex.EncodeToString(h[:])
+
+	// The checksum is stored with the data, whether it's computed up front
+	// or while uploading.
+	if err := cl.PutBytes(data, testBucket, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Put(bytes.NewBuffer(data), testBucket, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutNC(bytes.NewBuffer(data), testBucket, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rPath := range []string{"a", "b", "c"} {
+		info, err := cl.Stat(testBucket, rPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Checksum != sum {
+			t.Fatal(rPath, info.Checksum)
+		}
+	}
+	if be.Calls != 0 {
+		t.Fatal(be.Calls)
+	}
+
+	// Reading verifies the checksum.
+	info, err := cl.Backend.Stat(ctx, testBucket, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Meta[metaChecksum] = "bad"
+	if err := cl.Backend.SetMeta(ctx, testBucket, "a", info.Meta); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := cl.Get(testBucket, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != ErrChecksumMismatch {
+		t.Fatal(err)
+	}
+
+	if err := cl.GetFile(testBucket, "a", "files/out/a"); err != ErrChecksumMismatch {
+		t.Fatal(err)
+	}
+}
+
+func TestClientChecksumGZ(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	if err := cl.PutDirTarGZ("files/d", testBucket, "dir.tgz"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := cl.Stat(testBucket, "dir.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Checksum) != 64 {
+		t.Fatal(info.Checksum)
+	}
+
+	info.Meta[metaChecksum] = "bad"
+	if err := cl.Backend.SetMeta(ctx, testBucket, "dir.tgz", info.Meta); err != nil {
+		t.Fatal(err)
+	}
+
+	err = cl.GetDirTarGZ(testBucket, "dir.tgz", "files/out/d")
+	if err != ErrChecksumMismatch {
+		t.Fatal(err)
+	}
+}
@@ -1,360 +1,312 @@
 package objstore
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
+	"context"
+	"errors"
 	"io"
-	"log"
-	"os"
-	"path/filepath"
-	"time"
-
-	minio "github.com/minio/minio-go"
+	"sync"
 )
 
+// listMetaWorkers is the number of objects stat'ed concurrently by ListMeta.
+const listMetaWorkers = 8
+
+// Client reads and writes objects, encrypting them with EncKey or Keys.
+// NewClientFromEnv returns a client configured by the environment.
+// Alternatively, set the fields and call Connect.
 type Client struct {
 	Host   string // Default from environment: SB_OBJSTORE_HOST
 	Key    string // Default from environment: SB_OBJSTORE_KEY
 	Secret string // Default from environment: SB_OBJSTORE_SECRET
 	EncKey []byte // Default from environment: SB_OBJSTORE_ENC_KEY
 
-	cl *minio.Client
+	// Keys, if set, replaces EncKey with a keyring supporting multiple keys.
+	Keys *Keyring
+
+	// Retry controls retries of failed operations. Default:
+	// DefaultRetryPolicy.
+	Retry *RetryPolicy
+
+	// Backend stores the objects. Connect sets it to a minio backend for
+	// Host. To use another backend, set it directly instead of calling
+	// Connect.
+	Backend Backend
 }
 
 // ----------------------------------------------------------------------------
 
 func (cl *Client) Connect() (err error) {
-	if cl.cl != nil {
-		cl.cl = nil
-	}
-
-	cl.cl, err = minio.New(cl.Host, cl.Key, cl.Secret, true)
-	if err != nil {
-		log.Printf("Failed to connect to object store: %v", err)
-	}
-
+	cl.Backend, err = NewMinioBackend(cl.Host, cl.Key, cl.Secret)
 	return err
 }
 
-func (cl *Client) withRetry(fn func() error) (err error) {
-	for i := 0; i < 4; i++ {
-		if err = fn(); err != nil {
-			log.Printf("Retrying...")
-			time.Sleep(8 * time.Second)
-			continue
-		}
-		break
-	}
-	return
-}
-
 // ----------------------------------------------------------------------------
 
-func (cl *Client) Put(r io.Reader, bucket, rPath string) error {
-	enc, err := encryptReader(cl.EncKey, r)
-	if err != nil {
-		return err
-	}
+// Put encrypts the data read from r and writes it to bucket/rPath. Like all
+// Put variants, it optionally takes the metadata to store with the object.
+// See PutObject for more options.
+func (cl *Client) Put(r io.Reader, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutContext(context.Background(), r, bucket, rPath, meta...)
+}
 
-	_, err = cl.cl.PutObject(bucket, rPath, enc, -1, minio.PutObjectOptions{
-		PartSize: 1024 * 1024 * 64,
+// PutContext is like Put. Failed uploads are only retried if r is an
+// io.Seeker, or if nothing was read from it yet.
+func (cl *Client) PutContext(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutObject(ctx, r, bucket, rPath, PutOptions{
+		ObjectMeta: optMeta(meta),
 	})
-	if err != nil {
-		log.Printf("Failed to put object %s/%s: %v", bucket, rPath, err)
-	}
-	return convertError(err)
 }
 
 // ----------------------------------------------------------------------------
 
-func (cl *Client) PutBytes(buf []byte, bucket, rPath string) error {
-	return cl.withRetry(func() error {
-		return cl.putBytes(buf, bucket, rPath)
-	})
+func (cl *Client) PutBytes(buf []byte, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutBytesContext(context.Background(), buf, bucket, rPath, meta...)
 }
 
-func (cl *Client) putBytes(buf []byte, bucket, rPath string) error {
-	return cl.Put(bytes.NewBuffer(buf), bucket, rPath)
+func (cl *Client) PutBytesContext(
+	ctx context.Context,
+	buf []byte,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutContext(ctx, bytes.NewReader(buf), bucket, rPath, meta...)
 }
 
 // ----------------------------------------------------------------------------
 
-// PutGZ: Like Put, but compresses the data stream before sending.
-func (cl *Client) PutGZ(r io.Reader, bucket, rPath string) error {
-	return cl.Put(gzipReader(r), bucket, rPath)
+// PutGZ: Like Put, but compresses the data stream before sending. This is
+// PutObject with Codec set to CodecGzip.
+func (cl *Client) PutGZ(r io.Reader, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutGZContext(context.Background(), r, bucket, rPath, meta...)
 }
 
-// ----------------------------------------------------------------------------
-
-func (cl *Client) PutFile(lPath, bucket, rPath string) (err error) {
-	return cl.withRetry(func() error {
-		return cl.putFile(lPath, bucket, rPath)
+func (cl *Client) PutGZContext(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutObject(ctx, r, bucket, rPath, PutOptions{
+		ObjectMeta: optMeta(meta),
+		Codec:      CodecGzip,
 	})
 }
 
-func (cl *Client) putFile(lPath, bucket, rPath string) error {
-	f, err := os.Open(lPath)
-	if err != nil {
-		log.Printf("Failed to open file: %v", lPath)
-		return err
-	}
-	defer f.Close()
-	return cl.Put(f, bucket, rPath)
-}
-
 // ----------------------------------------------------------------------------
 
-func (cl *Client) PutFileGZ(lPath, bucket, rPath string) error {
-	return cl.withRetry(func() error {
-		return cl.putFileGZ(lPath, bucket, rPath)
-	})
+func (cl *Client) PutFile(lPath, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutFileContext(context.Background(), lPath, bucket, rPath, meta...)
 }
 
-func (cl *Client) putFileGZ(lPath, bucket, rPath string) error {
-	f, err := os.Open(lPath)
-	if err != nil {
-		log.Printf("Failed to open file: %v", lPath)
-		return err
-	}
-	defer f.Close()
-
-	return cl.PutGZ(f, bucket, rPath)
+func (cl *Client) PutFileContext(
+	ctx context.Context,
+	lPath,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutObjectFile(ctx, lPath, bucket, rPath, PutOptions{
+		ObjectMeta: optMeta(meta),
+	})
 }
 
 // ----------------------------------------------------------------------------
 
-func (cl *Client) PutDirTarGZ(lPath, bucket, rPath string) error {
-	return cl.withRetry(func() error {
-		return cl.putDirTarGZ(lPath, bucket, rPath)
-	})
+func (cl *Client) PutFileGZ(lPath, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutFileGZContext(context.Background(), lPath, bucket, rPath, meta...)
 }
 
-func (cl *Client) putDirTarGZ(lPath, bucket, rPath string) error {
-	r, w := io.Pipe()
-	go func() {
-		defer w.Close()
-
-		paths, err := filepath.Glob(filepath.Join(lPath, "*"))
-		if err != nil {
-			log.Printf("Failed to list local files: %v", err)
-			w.CloseWithError(err)
-			return
-		}
-
-		tw := tar.NewWriter(w)
-		defer tw.Close()
-
-		for _, path := range paths {
-			fSrc, err := os.Open(path)
-			if err != nil {
-				log.Printf("Failed to open file %s: %v", path, err)
-				w.CloseWithError(err)
-				return
-			}
-			fInfo, err := fSrc.Stat()
-			if err != nil {
-				log.Printf("Failed to stat file %s: %v", path, err)
-				w.CloseWithError(err)
-				return
-			}
-
-			// Write the header.
-			err = tw.WriteHeader(&tar.Header{
-				Typeflag: tar.TypeReg,
-				Name:     filepath.Base(path),
-				Size:     fInfo.Size(),
-				Mode:     int64(fInfo.Mode()),
-			})
-			if err != nil {
-				log.Printf("Failed to write tar header: %v", err)
-				w.CloseWithError(err)
-				return
-			}
+func (cl *Client) PutFileGZContext(
+	ctx context.Context,
+	lPath,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutObjectFile(ctx, lPath, bucket, rPath, PutOptions{
+		ObjectMeta: optMeta(meta),
+		Codec:      CodecGzip,
+	})
+}
 
-			// Write the data.
-			if _, err = io.Copy(tw, fSrc); err != nil {
-				log.Printf("Failed to write file to tar archive: %v", err)
-				w.CloseWithError(err)
-				return
-			}
+// ----------------------------------------------------------------------------
 
-			// Ignore close error.
-			fSrc.Close()
-		}
+// PutDirTarGZ: Like PutDir, compressing the archive with gzip.
+func (cl *Client) PutDirTarGZ(lPath, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutDirTarGZContext(context.Background(), lPath, bucket, rPath, meta...)
+}
 
-	}()
-	return cl.PutGZ(r, bucket, rPath)
+func (cl *Client) PutDirTarGZContext(
+	ctx context.Context,
+	lPath,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutDir(ctx, lPath, bucket, rPath, PutDirOptions{
+		PutOptions: PutOptions{
+			ObjectMeta: optMeta(meta),
+			Codec:      CodecGzip,
+		},
+	})
 }
 
 // ----------------------------------------------------------------------------
 
+// Get returns the data of bucket/rPath, decrypted and decompressed as recorded
+// when it was written. See GetObject for more options.
 func (cl *Client) Get(bucket, rPath string) (io.ReadCloser, error) {
-	obj, err := cl.cl.GetObject(bucket, rPath, minio.GetObjectOptions{})
-	if err != nil {
-		log.Printf("Failed to get object: %v", err)
-		return nil, convertError(err)
-	}
-
-	r, err := decryptReader(cl.EncKey, obj)
-	if err != nil {
-		return nil, convertError(err)
-	}
+	return cl.GetContext(context.Background(), bucket, rPath)
+}
 
-	return struct {
-		io.Reader
-		io.Closer
-	}{
-		Reader: r,
-		Closer: obj,
-	}, nil
+// GetContext is like Get. Reading from the returned object fails once ctx is
+// done.
+func (cl *Client) GetContext(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	io.ReadCloser,
+	error,
+) {
+	return cl.GetObject(ctx, bucket, rPath, GetOptions{})
 }
 
 // ----------------------------------------------------------------------------
 
-// GetGZ: like Get, but decompresses the data stream.
+// GetGZ: like Get, but decompresses the data stream also for objects written
+// before compression was recorded.
 func (cl *Client) GetGZ(bucket, rPath string) (io.ReadCloser, error) {
-	r, err := cl.Get(bucket, rPath)
-	if err != nil {
-		return nil, err
-	}
-	gr, err := gzip.NewReader(r)
-	if err != nil {
-		log.Printf("Failed to create gzip reader: %v", err)
-		return nil, err
-	}
-	return struct {
-		io.Reader
-		io.Closer
-	}{
-		Reader: gr,
-		Closer: r,
-	}, nil
+	return cl.GetGZContext(context.Background(), bucket, rPath)
+}
+
+func (cl *Client) GetGZContext(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	io.ReadCloser,
+	error,
+) {
+	return cl.GetObject(ctx, bucket, rPath, GetOptions{Codec: CodecGzip})
 }
 
 // ----------------------------------------------------------------------------
 
 func (cl *Client) GetFile(bucket, rPath, lPath string) error {
-	r, err := cl.Get(bucket, rPath)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	f, err := os.Create(lPath)
-	if err != nil {
-		log.Printf("Failed to create file: %v", err)
-		return err
-	}
-	defer f.Close()
+	return cl.GetFileContext(context.Background(), bucket, rPath, lPath)
+}
 
-	if _, err := io.Copy(f, r); err != nil {
-		log.Printf("Failed to copy object to file: %v", err)
-		return err
-	}
-	return nil
+func (cl *Client) GetFileContext(
+	ctx context.Context,
+	bucket,
+	rPath,
+	lPath string,
+) error {
+	return cl.GetObjectFile(ctx, bucket, rPath, lPath, GetOptions{})
 }
 
 // ----------------------------------------------------------------------------
 
 func (cl *Client) GetFileGZ(bucket, rPath, lPath string) error {
-	r, err := cl.GetGZ(bucket, rPath)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	f, err := os.Create(lPath)
-	if err != nil {
-		log.Printf("Failed to create file: %v", err)
-		return err
-	}
-	defer f.Close()
+	return cl.GetFileGZContext(context.Background(), bucket, rPath, lPath)
+}
 
-	if _, err := io.Copy(f, r); err != nil {
-		log.Printf("Failed to copy object to file: %v", err)
-		return err
-	}
-	return nil
+func (cl *Client) GetFileGZContext(
+	ctx context.Context,
+	bucket,
+	rPath,
+	lPath string,
+) error {
+	return cl.GetObjectFile(ctx, bucket, rPath, lPath, GetOptions{Codec: CodecGzip})
 }
 
 // ----------------------------------------------------------------------------
 
+// GetDirTarGZ: Like GetDir. Archives written before compression was recorded
+// are assumed to be compressed with gzip.
 func (cl *Client) GetDirTarGZ(bucket, rPath, lPath string) error {
-	f, err := cl.GetGZ(bucket, rPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if err := os.RemoveAll(lPath); err != nil {
-		log.Printf("Failed to remove local directory %s: %v", lPath, err)
-		return err
-	}
-
-	if err := os.MkdirAll(lPath, 0700); err != nil {
-		log.Printf("Failed to create local directory %s: %v", lPath, err)
-		return err
-	}
-
-	r := tar.NewReader(f)
-
-	for {
-		header, err := r.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("Failed to read tar header: %v", err)
-			return err
-		}
+	return cl.GetDirTarGZContext(context.Background(), bucket, rPath, lPath)
+}
 
-		dstPath := filepath.Join(lPath, header.Name)
-		dst, err := os.Create(dstPath)
+func (cl *Client) GetDirTarGZContext(
+	ctx context.Context,
+	bucket,
+	rPath,
+	lPath string,
+) error {
+	return cl.GetDir(ctx, bucket, rPath, lPath, GetDirOptions{
+		GetOptions: GetOptions{Codec: CodecGzip},
+	})
+}
 
-		if err != nil {
-			log.Printf("Failed to create file %s: %v", dstPath, err)
-			return err
-		}
+// ----------------------------------------------------------------------------
 
-		if _, err = io.CopyN(dst, r, header.Size); err != nil {
-			log.Printf("Failed to write file %s: %v", dstPath, err)
-			return err
-		}
+func (cl *Client) Delete(bucket string, rPaths ...string) error {
+	return cl.DeleteContext(context.Background(), bucket, rPaths...)
+}
 
-		if err := dst.Close(); err != nil {
-			log.Printf("Failed to close file %s: %v", dstPath, err)
-			return err
+func (cl *Client) DeleteContext(
+	ctx context.Context,
+	bucket string,
+	rPaths ...string,
+) error {
+	return cl.withRetry(ctx, func() error {
+		err := cl.Backend.Delete(ctx, bucket, rPaths...)
+
+		// Only retry the objects that failed.
+		var multiErr *MultiError
+		if errors.As(err, &multiErr) {
+			rPaths = multiErr.Keys()
 		}
-	}
-
-	return nil
+		return err
+	})
 }
 
 // ----------------------------------------------------------------------------
 
-func (cl *Client) Delete(bucket string, rPaths ...string) error {
-	rPathCh := make(chan string, len(rPaths))
-	for _, s := range rPaths {
-		rPathCh <- s
+func (cl *Client) List(
+	bucket,
+	prefix string,
+	recursive bool,
+) (
+	[]FileInfo,
+	error,
+) {
+	return cl.ListContext(context.Background(), bucket, prefix, recursive)
+}
+
+func (cl *Client) ListContext(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	recursive bool,
+) (
+	[]FileInfo,
+	error,
+) {
+	l := []FileInfo{}
+	it := cl.NewLister(ctx, bucket, prefix, ListOptions{Recursive: recursive})
+	for it.Next() {
+		l = append(l, it.Info())
 	}
-	close(rPathCh)
-
-	var err error
-	for rErr := range cl.cl.RemoveObjects(bucket, rPathCh) {
-		if rErr.Err != nil {
-			log.Printf("Failed to delete object %s: %v", rErr.ObjectName, rErr.Err)
-			if err == nil {
-				err = convertError(rErr.Err)
-			}
-		}
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
-	return err
+	return l, nil
 }
 
-// ----------------------------------------------------------------------------
-
-func (cl *Client) List(
+// ListMeta is like List, but also returns the metadata of each object, as
+// returned by Stat. This takes a request per object.
+func (cl *Client) ListMeta(
 	bucket,
 	prefix string,
 	recursive bool,
@@ -362,43 +314,116 @@ func (cl *Client) List(
 	[]FileInfo,
 	error,
 ) {
-	// Create a done channel to control 'ListObjectsV2' go routine.
-	doneCh := make(chan struct{})
+	return cl.ListMetaContext(context.Background(), bucket, prefix, recursive)
+}
 
-	// Indicate to our routine to exit cleanly upon return.
-	defer close(doneCh)
+func (cl *Client) ListMetaContext(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	recursive bool,
+) (
+	[]FileInfo,
+	error,
+) {
+	l, err := cl.ListContext(ctx, bucket, prefix, recursive)
+	if err != nil {
+		return nil, err
+	}
 
-	l := []FileInfo{}
-	objectCh := cl.cl.ListObjectsV2(bucket, prefix, recursive, doneCh)
-	for obj := range objectCh {
-		if obj.Err != nil {
-			log.Printf("Error listing objects: %v", obj.Err)
-			return nil, convertError(obj.Err)
+	idxCh := make(chan int, len(l))
+	for i := range l {
+		// Skip common prefixes in non-recursive listings.
+		if !l[i].IsDir {
+			idxCh <- i
 		}
-		l = append(l, FileInfo{
-			Name:    obj.Key,
-			ModTime: obj.LastModified.UTC(),
-			Size:    obj.Size,
-		})
+	}
+	close(idxCh)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for i := 0; i < listMetaWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxCh {
+				info, err := cl.StatContext(ctx, bucket, l[idx].Name)
+				if err == ErrPathNotFound {
+					// Deleted since listing.
+					l[idx].Name = ""
+					continue
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				l[idx] = info
+			}
+		}()
 	}
 
-	return l, nil
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	found := l[:0]
+	for _, info := range l {
+		if info.Name != "" {
+			found = append(found, info)
+		}
+	}
+	return found, nil
 }
 
 func (cl *Client) ListNames(bucket, prefix string) ([]string, error) {
-	l, err := cl.List(bucket, prefix, false)
-	if err != nil {
-		return nil, err
+	return cl.ListNamesContext(context.Background(), bucket, prefix)
+}
+
+func (cl *Client) ListNamesContext(
+	ctx context.Context,
+	bucket,
+	prefix string,
+) (
+	[]string,
+	error,
+) {
+	ls := []string{}
+	it := cl.NewLister(ctx, bucket, prefix, ListOptions{})
+	for it.Next() {
+		ls = append(ls, it.Info().Name)
 	}
-	ls := make([]string, len(l))
-	for i := range ls {
-		ls[i] = l[i].Name
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
 	return ls, nil
 }
 
+// ListBaseNames returns the base names of the entries listed non-recursively
+// with the given prefix. Common prefixes lose their trailing "/", so use List
+// or a Lister to tell them apart from objects by FileInfo.IsDir.
 func (cl *Client) ListBaseNames(bucket, prefix string) ([]string, error) {
-	l, err := cl.ListNames(bucket, prefix)
+	return cl.ListBaseNamesContext(context.Background(), bucket, prefix)
+}
+
+func (cl *Client) ListBaseNamesContext(
+	ctx context.Context,
+	bucket,
+	prefix string,
+) (
+	[]string,
+	error,
+) {
+	l, err := cl.ListNamesContext(ctx, bucket, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -411,38 +436,78 @@ func (cl *Client) ListBaseNames(bucket, prefix string) ([]string, error) {
 // ----------------------------------------------------------------------------
 
 func (cl *Client) Stat(bucket, rPath string) (FileInfo, error) {
-	info, err := cl.cl.StatObject(bucket, rPath, minio.StatObjectOptions{})
-	if err != nil {
-		log.Printf("Failed to stat object: %v", err)
-		return FileInfo{}, convertError(err)
-	}
-	return FileInfo{
-		Name:    info.Key,
-		ModTime: info.LastModified.UTC(),
-		Size:    info.Size,
-	}, nil
+	return cl.StatContext(context.Background(), bucket, rPath)
+}
+
+func (cl *Client) StatContext(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	FileInfo,
+	error,
+) {
+	var info FileInfo
+	err := cl.withRetry(ctx, func() (err error) {
+		info, err = cl.Backend.Stat(ctx, bucket, rPath)
+		return err
+	})
+	info.setMetaFields()
+	return info, err
 }
 
 // ----------------------------------------------------------------------------
 
+// CopyOptions configures CopyObject.
+type CopyOptions struct {
+	// ReplaceMeta replaces the metadata given when the source was written
+	// with ObjectMeta. Otherwise, it's copied. The metadata recorded by this
+	// package, such as the checksum, is copied in both cases.
+	ReplaceMeta bool
+	ObjectMeta
+}
+
 func (cl *Client) Copy(bucket, srcPath, dstPath string) error {
-	// Source object.
-	src := minio.NewSourceInfo(bucket, srcPath, nil)
+	return cl.CopyContext(context.Background(), bucket, srcPath, dstPath)
+}
 
-	// Destination object.
-	dst, err := minio.NewDestinationInfo(bucket, dstPath, nil, nil)
-	if err != nil {
-		log.Printf("Failed to create destination: %v", err)
-		return convertError(err)
+func (cl *Client) CopyContext(
+	ctx context.Context,
+	bucket,
+	srcPath,
+	dstPath string,
+) error {
+	return cl.CopyObject(ctx, bucket, srcPath, bucket, dstPath, CopyOptions{})
+}
+
+// CopyObject copies srcBucket/srcPath to dstBucket/dstPath on the server, as
+// configured by opts. The buckets may differ. Objects of any size can be
+// copied.
+func (cl *Client) CopyObject(
+	ctx context.Context,
+	srcBucket,
+	srcPath,
+	dstBucket,
+	dstPath string,
+	opts CopyOptions,
+) error {
+	// Without new metadata, the object would be copied onto itself.
+	if srcBucket == dstBucket && srcPath == dstPath && !opts.ReplaceMeta {
+		return nil
 	}
 
-	// Copy object call.
-	if err = cl.cl.CopyObject(dst, src); err != nil {
-		log.Printf("Failed to copy %s -> %s: %v", srcPath, dstPath, err)
-		return convertError(err)
+	var meta map[string]string
+	if opts.ReplaceMeta {
+		info, err := cl.StatContext(ctx, srcBucket, srcPath)
+		if err != nil {
+			return err
+		}
+		meta = replaceMeta(info.Meta, opts.ObjectMeta)
 	}
 
-	return nil
+	return cl.withRetry(ctx, func() error {
+		return cl.Backend.Copy(ctx, srcBucket, srcPath, dstBucket, dstPath, meta)
+	})
 }
 
 // ----------------------------------------------------------------------------
@@ -1,7 +1,10 @@
 package objstore
 
 import (
+	"context"
+	"io/ioutil"
 	"testing"
+	"time"
 )
 
 func TestClientPutFileGetFile(t *testing.T) {
@@ -108,3 +111,145 @@ func TestClientStatNotFound(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestClientCopyObject(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	meta := ObjectMeta{
+		ContentType: "text/plain",
+		UserMeta:    map[string]string{"Job": "a"},
+	}
+	if err := cl.PutFileGZ("files/in.txt", testBucket, "a", meta); err != nil {
+		t.Fatal(err)
+	}
+	src, err := cl.Stat(testBucket, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata is copied, or replaced, except for the recorded checksum etc.
+	err = cl.CopyObject(ctx, testBucket, "a", testBucket, "b", CopyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.CopyObject(ctx, testBucket, "a", testBucket, "c", CopyOptions{
+		ReplaceMeta: true,
+		ObjectMeta:  ObjectMeta{UserMeta: map[string]string{"Job": "c"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := cl.Stat(testBucket, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ContentType != "text/plain" || b.UserMeta["Job"] != "a" || b.Checksum != src.Checksum {
+		t.Fatal(b)
+	}
+
+	c, err := cl.Stat(testBucket, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ContentType != "" || c.ContentEncoding != "gzip" || c.UserMeta["Job"] != "c" ||
+		c.Checksum != src.Checksum || c.Encryption != src.Encryption {
+		t.Fatal(c)
+	}
+
+	if err := cl.GetFile(testBucket, "c", "files/out/in.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if !pathsMatch("files/in.txt", "files/out/in.txt") {
+		t.Fatal("files/in.txt")
+	}
+
+	// Metadata can be replaced in place.
+	err = cl.CopyObject(ctx, testBucket, "c", testBucket, "c", CopyOptions{
+		ReplaceMeta: true,
+		ObjectMeta:  meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = cl.Stat(testBucket, "c"); err != nil || c.UserMeta["Job"] != "a" {
+		t.Fatal(c, err)
+	}
+
+	err = cl.CopyObject(ctx, testBucket, "x", testBucket, "y", CopyOptions{ReplaceMeta: true})
+	if err != ErrPathNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestClientReencrypt(t *testing.T) {
+	cl := NewClientForTesting()
+
+	rPath := "a/b/c/file.txt"
+
+	err := cl.PutFile("files/in.txt", testBucket, rPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := []byte("abcdefghijklmnopqrstuvwxyz012345")
+
+	cl.Keys = &Keyring{
+		Primary: "new",
+		Keys:    map[string][]byte{"": cl.EncKey, "new": newKey},
+	}
+
+	if err := cl.Reencrypt(testBucket, rPath); err != nil {
+		t.Fatal(err)
+	}
+
+	cl.Keys = &Keyring{
+		Primary: "new",
+		Keys:    map[string][]byte{"new": newKey},
+	}
+
+	err = cl.GetFile(testBucket, rPath, "files/out/in.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pathsMatch("files/in.txt", "files/out/in.txt") {
+		t.Fatal("files/in.txt")
+	}
+}
+
+func TestClientContextCancel(t *testing.T) {
+	cl := NewClientForTesting()
+
+	rPath := "a/b/c/file.txt"
+
+	err := cl.PutFile("files/in.txt", testBucket, rPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r, err := cl.GetContext(ctx, testBucket, rPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cancel()
+
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("read after cancel")
+	}
+
+	// Retries are abandoned once the context is cancelled.
+	start := time.Now()
+	err = cl.PutDirTarGZContext(ctx, "files/d", testBucket, "q/r/s/my-dir.tar.gz")
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal(time.Since(start))
+	}
+}
@@ -0,0 +1,141 @@
+// Command objreencrypt re-encrypts the objects below a prefix with the primary
+// key of a keyring, e.g. after a key rotation or to encrypt objects written
+// with PutNC.
+//
+// Usage:
+//
+//	objreencrypt [flags] <bucket> <prefix>
+//
+// The object store is configured by the environment, see
+// objstore.ConfigFromEnv. The -keyring flag overrides SB_OBJSTORE_KEYRING.
+//
+// Objects written before their encryption was recorded may be plaintext or
+// encrypted in the legacy format, and fail unless they match a -plaintext or
+// -legacy pattern, e.g.:
+//
+//	objreencrypt -plaintext 'logs/*' -legacy 'data/*/*' <bucket> <prefix>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Suburbia-io/cloud/objstore"
+)
+
+// patterns is a flag that may be given several times.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	*p = append(*p, pattern)
+	return nil
+}
+
+// match returns true if name matches one of the patterns.
+func (p patterns) match(name string) bool {
+	for _, pattern := range p {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	var (
+		keyringPath = flag.String("keyring", "", "keyring JSON file")
+		workers     = flag.Int("workers", 8, "number of objects processed concurrently")
+		dryRun      = flag.Bool("dry-run", false, "report what would be done without writing anything")
+
+		plaintext patterns
+		legacy    patterns
+	)
+	flag.Var(&plaintext, "plaintext",
+		"treat unrecorded objects matching `pattern` as plaintext (repeatable)")
+	flag.Var(&legacy, "legacy",
+		"treat unrecorded objects matching `pattern` as legacy encrypted (repeatable)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <bucket> <prefix>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	bucket, prefix := flag.Arg(0), flag.Arg(1)
+
+	conf, err := objstore.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to read configuration: %v", err)
+	}
+	if *keyringPath != "" {
+		conf.Keyring = *keyringPath
+	}
+
+	cl, err := objstore.NewClient(conf)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+
+	report, err := cl.ReencryptPrefix(bucket, prefix, objstore.ReencryptOptions{
+		Workers: *workers,
+		DryRun:  *dryRun,
+		Classify: func(info objstore.FileInfo) string {
+			isPlaintext, isLegacy := plaintext.match(info.Name), legacy.match(info.Name)
+			switch {
+			case isPlaintext && !isLegacy:
+				return objstore.EncryptionNone
+			case isLegacy && !isPlaintext:
+				return objstore.EncryptionLegacy
+			}
+			return ""
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to list objects: %v", err)
+	}
+
+	verb := ""
+	if *dryRun {
+		verb = "to be "
+	}
+
+	for _, rPath := range report.Rewrapped {
+		fmt.Printf("%srewrapped: %s\n", verb, rPath)
+	}
+	for _, rPath := range report.Reencrypted {
+		fmt.Printf("%sre-encrypted: %s\n", verb, rPath)
+	}
+	for _, rPath := range report.Encrypted {
+		fmt.Printf("%sencrypted: %s\n", verb, rPath)
+	}
+	for rPath, err := range report.Failed {
+		fmt.Printf("failed: %s: %v\n", rPath, err)
+	}
+
+	fmt.Printf(
+		"%d current, %d %srewrapped, %d %sre-encrypted, %d %sencrypted, %d failed\n",
+		len(report.Current),
+		len(report.Rewrapped), verb,
+		len(report.Reencrypted), verb,
+		len(report.Encrypted), verb,
+		len(report.Failed))
+
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,122 @@
+// Command objsync mirrors a local directory to objects below a prefix, or the
+// other way around, copying only the files that are missing or differ.
+//
+// Usage:
+//
+//	objsync [flags] up <dir> <bucket> <prefix>
+//	objsync [flags] down <bucket> <prefix> <dir>
+//
+// The object store is configured by the environment, see
+// objstore.ConfigFromEnv.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Suburbia-io/cloud/objstore"
+)
+
+// patterns collects the values of a repeated flag.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(val string) error {
+	*p = append(*p, val)
+	return nil
+}
+
+func main() {
+	var (
+		include, exclude patterns
+
+		del       = flag.Bool("delete", false, "delete files or objects missing from the source")
+		dryRun    = flag.Bool("dry-run", false, "report what would be done without writing anything")
+		mtime     = flag.Bool("mtime", false, "compare sizes and modification times instead of checksums")
+		workers   = flag.Int("workers", 8, "number of files processed concurrently")
+		codec     = flag.String("codec", "", "compress uploaded files with the given codec, e.g. gzip")
+		noEncrypt = flag.Bool("no-encrypt", false, "upload files unencrypted")
+	)
+	flag.Var(&include, "include", "only sync files matching the pattern (repeatable)")
+	flag.Var(&exclude, "exclude", "skip files matching the pattern (repeatable)")
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] up <dir> <bucket> <prefix>\n", os.Args[0])
+		fmt.Fprintf(out, "       %s [flags] down <bucket> <prefix> <dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 4 || (flag.Arg(0) != "up" && flag.Arg(0) != "down") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := objstore.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to read configuration: %v", err)
+	}
+
+	cl, err := objstore.NewClient(conf)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+
+	opts := objstore.SyncOptions{
+		Put: objstore.PutOptions{
+			NoEncrypt: *noEncrypt,
+			Codec:     *codec,
+		},
+		Include: include,
+		Exclude: exclude,
+		Delete:  *del,
+		DryRun:  *dryRun,
+		Workers: *workers,
+	}
+	if *mtime {
+		opts.Compare = objstore.SyncModTime
+	}
+
+	var report objstore.SyncReport
+	if flag.Arg(0) == "up" {
+		report, err = cl.SyncUp(flag.Arg(1), flag.Arg(2), flag.Arg(3), opts)
+	} else {
+		report, err = cl.SyncDown(flag.Arg(1), flag.Arg(2), flag.Arg(3), opts)
+	}
+	if err != nil {
+		log.Fatalf("Failed to list files: %v", err)
+	}
+
+	verb := ""
+	if *dryRun {
+		verb = "to be "
+	}
+
+	for _, name := range report.Copied {
+		fmt.Printf("%scopied: %s\n", verb, name)
+	}
+	for _, name := range report.Deleted {
+		fmt.Printf("%sdeleted: %s\n", verb, name)
+	}
+	for name, err := range report.Failed {
+		fmt.Printf("failed: %s: %v\n", name, err)
+	}
+
+	fmt.Printf(
+		"%d unchanged, %d %scopied, %d %sdeleted, %d failed\n",
+		len(report.Unchanged),
+		len(report.Copied), verb,
+		len(report.Deleted), verb,
+		len(report.Failed))
+
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
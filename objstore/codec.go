@@ -0,0 +1,97 @@
+package objstore
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Codec is a compression algorithm, see RegisterCodec.
+type Codec interface {
+	// NewWriter returns a writer compressing the data written to it into w.
+	// Level 0 selects the codec's default, other levels are codec specific.
+	// The writer is closed once all data has been written.
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+
+	// NewReader returns a reader decompressing the data read from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: map[string]Codec{
+		CodecGzip: gzipCodec{},
+	},
+}
+
+// RegisterCodec makes a codec available under the given name, for use in
+// PutOptions.Codec. The name is stored with each object compressed with the
+// codec, and is used by Get to pick the decoder, so it must not change. Gzip
+// is registered by default, and the codecs subpackage registers zstd and s2.
+func RegisterCodec(name string, c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[name] = c
+}
+
+func lookupCodec(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[name]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return c, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// compressReader returns a reader returning the data read from r compressed
+// with the named codec, or unchanged if codec is empty. Closing it stops the
+// compression goroutine.
+func compressReader(codec string, level int, r io.Reader) (io.ReadCloser, error) {
+	if codec == "" {
+		return ioutil.NopCloser(r), nil
+	}
+
+	c, err := lookupCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+
+	// Create the writer up front so invalid levels are reported right away.
+	cw, err := c.NewWriter(pw, level)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if _, err := io.Copy(cw, r); err != nil {
+			// Release the writer's resources. Its error is of no interest.
+			cw.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(cw.Close())
+	}()
+
+	return pr, nil
+}
+
+// decompressReader returns a reader returning the data read from r
+// decompressed with the named codec, or unchanged if codec is empty.
+func decompressReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	if codec == "" {
+		return ioutil.NopCloser(r), nil
+	}
+
+	c, err := lookupCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewReader(r)
+}
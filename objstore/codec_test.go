@@ -0,0 +1,80 @@
+package objstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+type flateCodec struct{}
+
+func (flateCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return flate.NewWriter(w, level)
+}
+
+func (flateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+func TestClientCodecs(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	RegisterCodec("flate", flateCodec{})
+
+	data := bytes.Repeat([]byte("Some data. "), 1000)
+
+	type TestCase struct {
+		Codec string
+		Level int
+	}
+
+	cases := []TestCase{
+		{CodecGzip, 0},
+		{CodecGzip, 9},
+		{"flate", 1},
+	}
+
+	for _, tc := range cases {
+		err := cl.PutObject(ctx, bytes.NewReader(data), testBucket, "c", PutOptions{
+			Codec:      tc.Codec,
+			CodecLevel: tc.Level,
+		})
+		if err != nil {
+			t.Fatal(tc, err)
+		}
+
+		info, err := cl.Stat(testBucket, "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ContentEncoding != tc.Codec || info.Size >= int64(len(data)) {
+			t.Fatal(tc, info)
+		}
+
+		r, err := cl.Get(testBucket, "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(buf, data) {
+			t.Fatal(tc, err)
+		}
+	}
+
+	// Invalid levels are reported before uploading.
+	err := cl.PutObject(ctx, bytes.NewReader(data), testBucket, "d", PutOptions{
+		Codec:      CodecGzip,
+		CodecLevel: 42,
+	})
+	if err == nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.Stat(testBucket, "d"); err != ErrPathNotFound {
+		t.Fatal(err)
+	}
+}
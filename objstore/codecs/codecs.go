@@ -0,0 +1,75 @@
+// Package codecs registers the zstd and s2 compression codecs with objstore.
+// Import it for its side effects:
+//
+//	import _ "github.com/Suburbia-io/cloud/objstore/codecs"
+//
+// Objects can then be written with PutOptions.Codec set to CodecZstd or
+// CodecS2. Readers of such objects need to import this package as well.
+package codecs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/Suburbia-io/cloud/objstore"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CodecZstd is zstd. Levels are those of the zstd command line tool, 1
+	// to 22, mapped onto the levels supported by the encoder. Default: 3.
+	CodecZstd = "zstd"
+
+	// CodecS2 is s2, a faster variant of snappy. Levels are 1 (default), 2
+	// for better and 3 for best compression.
+	CodecS2 = "s2"
+)
+
+var errInvalidLevel = errors.New("InvalidLevel")
+
+func init() {
+	objstore.RegisterCodec(CodecZstd, zstdCodec{})
+	objstore.RegisterCodec(CodecS2, s2Codec{})
+}
+
+// ----------------------------------------------------------------------------
+
+type zstdCodec struct{}
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = 3
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+// ----------------------------------------------------------------------------
+
+type s2Codec struct{}
+
+func (s2Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	switch level {
+	case 0, 1:
+		return s2.NewWriter(w), nil
+	case 2:
+		return s2.NewWriter(w, s2.WriterBetterCompression()), nil
+	case 3:
+		return s2.NewWriter(w, s2.WriterBestCompression()), nil
+	default:
+		return nil, errInvalidLevel
+	}
+}
+
+func (s2Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(s2.NewReader(r)), nil
+}
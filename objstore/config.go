@@ -0,0 +1,164 @@
+package objstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Config holds the settings used by NewClient. The environment variable for
+// each field is used by ConfigFromEnv.
+type Config struct {
+	Host   string // SB_OBJSTORE_HOST
+	Key    string // SB_OBJSTORE_KEY
+	Secret string // SB_OBJSTORE_SECRET
+	Region string // SB_OBJSTORE_REGION. Default: detected by the client.
+
+	// Encryption key, 16, 24 or 32 bytes long. Ignored if Keyring is set.
+	EncKey string // SB_OBJSTORE_ENC_KEY
+
+	// Path to a keyring file, see LoadKeyring.
+	Keyring string // SB_OBJSTORE_KEYRING
+
+	// DisableTLS connects to Host over plain HTTP instead of HTTPS, e.g. for
+	// a local test server. Requests, including metadata and data written
+	// with NoEncrypt, are then sent in the clear.
+	DisableTLS bool // SB_OBJSTORE_DISABLE_TLS
+
+	CAFile string // SB_OBJSTORE_CA_FILE: PEM bundle of CAs to trust.
+
+	// Connection pool sizes. Default: those of http.DefaultTransport.
+	MaxIdleConns        int // SB_OBJSTORE_MAX_IDLE_CONNS
+	MaxIdleConnsPerHost int // SB_OBJSTORE_MAX_IDLE_CONNS_PER_HOST
+
+	// Transport, if set, is used for all requests. CAFile and the pool sizes
+	// are ignored.
+	Transport http.RoundTripper `json:"-"`
+
+	// LocalRoot, if set, stores objects on local disk below this directory
+	// instead of connecting to Host. See NewLocalBackend.
+	LocalRoot string // SB_OBJSTORE_LOCAL_ROOT
+}
+
+// LoadConfig reads a Config from the JSON file at path. Field names are those
+// of the Config struct.
+func LoadConfig(path string) (Config, error) {
+	conf := Config{}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read config %s: %v", path, err)
+		return conf, err
+	}
+
+	if err := json.Unmarshal(buf, &conf); err != nil {
+		log.Printf("Failed to parse config %s: %v", path, err)
+		return conf, err
+	}
+
+	return conf, nil
+}
+
+// ConfigFromEnv returns the Config given by the environment variables listed
+// in Config. If SB_OBJSTORE_CONFIG is set, the configuration is first read
+// from that file using LoadConfig, and variables set in the environment take
+// precedence.
+func ConfigFromEnv() (Config, error) {
+	conf := Config{}
+
+	if path := os.Getenv("SB_OBJSTORE_CONFIG"); path != "" {
+		var err error
+		if conf, err = LoadConfig(path); err != nil {
+			return conf, err
+		}
+	}
+
+	for name, ptr := range map[string]*string{
+		"SB_OBJSTORE_HOST":       &conf.Host,
+		"SB_OBJSTORE_KEY":        &conf.Key,
+		"SB_OBJSTORE_SECRET":     &conf.Secret,
+		"SB_OBJSTORE_REGION":     &conf.Region,
+		"SB_OBJSTORE_ENC_KEY":    &conf.EncKey,
+		"SB_OBJSTORE_KEYRING":    &conf.Keyring,
+		"SB_OBJSTORE_CA_FILE":    &conf.CAFile,
+		"SB_OBJSTORE_LOCAL_ROOT": &conf.LocalRoot,
+	} {
+		if s, ok := os.LookupEnv(name); ok {
+			*ptr = s
+		}
+	}
+
+	if s, ok := os.LookupEnv("SB_OBJSTORE_DISABLE_TLS"); ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Printf("Invalid SB_OBJSTORE_DISABLE_TLS: %v", err)
+			return conf, err
+		}
+		conf.DisableTLS = b
+	}
+
+	for name, ptr := range map[string]*int{
+		"SB_OBJSTORE_MAX_IDLE_CONNS":          &conf.MaxIdleConns,
+		"SB_OBJSTORE_MAX_IDLE_CONNS_PER_HOST": &conf.MaxIdleConnsPerHost,
+	} {
+		if s, ok := os.LookupEnv(name); ok {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				log.Printf("Invalid %s: %v", name, err)
+				return conf, err
+			}
+			*ptr = i
+		}
+	}
+
+	return conf, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// NewClient returns a connected Client for the given configuration. The
+// encryption key or keyring is validated before connecting.
+func NewClient(conf Config) (*Client, error) {
+	cl := &Client{
+		Host:   conf.Host,
+		Key:    conf.Key,
+		Secret: conf.Secret,
+		EncKey: []byte(conf.EncKey),
+	}
+
+	if conf.Keyring != "" {
+		keys, err := LoadKeyring(conf.Keyring)
+		if err != nil {
+			return nil, err
+		}
+		cl.Keys = keys
+	} else if err := validateEncKey(cl.EncKey); err != nil {
+		return nil, err
+	}
+
+	if conf.LocalRoot != "" {
+		cl.Backend = NewLocalBackend(conf.LocalRoot)
+		return cl, nil
+	}
+
+	be, err := NewMinioBackendWithConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	cl.Backend = be
+
+	return cl, nil
+}
+
+// NewClientFromEnv returns a connected Client configured by the environment,
+// see ConfigFromEnv.
+func NewClientFromEnv() (*Client, error) {
+	conf, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conf)
+}
@@ -0,0 +1,78 @@
+package objstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	NewClientForTesting()
+
+	confPath := "files/out/objstore.json"
+	err := ioutil.WriteFile(confPath, []byte(`{"Host": "file.host", "Region": "eu"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"SB_OBJSTORE_CONFIG":         confPath,
+		"SB_OBJSTORE_HOST":           "env.host",
+		"SB_OBJSTORE_DISABLE_TLS":    "true",
+		"SB_OBJSTORE_MAX_IDLE_CONNS": "7",
+	}
+	for name, val := range env {
+		old, ok := os.LookupEnv(name)
+		os.Setenv(name, val)
+		if ok {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	conf, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Host != "env.host" || conf.Region != "eu" {
+		t.Fatal(conf)
+	}
+	if !conf.DisableTLS || conf.MaxIdleConns != 7 {
+		t.Fatal(conf)
+	}
+
+	os.Setenv("SB_OBJSTORE_DISABLE_TLS", "maybe")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	NewClientForTesting()
+
+	_, err := NewClient(Config{LocalRoot: testLocalRoot, EncKey: "short"})
+	if err != ErrInvalidEncKey {
+		t.Fatal(err)
+	}
+
+	cl, err := NewClient(Config{LocalRoot: testLocalRoot, EncKey: string(testEncKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutBytes([]byte("Some data."), testBucket, "a"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := cl.Get(testBucket, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "Some data." {
+		t.Fatal(string(buf))
+	}
+}
@@ -1,44 +1,318 @@
 package objstore
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
 	"io"
 	"log"
+	"sync"
+)
+
+// Encrypted objects are written in a chunked AES-GCM format:
+//
+//	header: magic (7 bytes) | version (1 byte)
+//	chunks: AES-GCM sealed segments of up to 64KiB of plaintext each
+//
+// Each object is encrypted with its own random data key. The data key is
+// stored in the object's metadata, encrypted with the keyring key whose ID is
+// stored along with it. Rotating keys therefore only requires replacing the
+// metadata, leaving the data alone, and a leaked data key exposes a single
+// object.
+//
+// The nonce of each chunk holds its sequence number and a flag marking the
+// final chunk, and the header is authenticated with every chunk. Truncation,
+// reordering and modification of the chunks is therefore detected when
+// decrypting.
+//
+// Objects written in the legacy format are decrypted with the keyring's legacy
+// key. The legacy format consists of a 16 byte IV followed by the AES-CTR
+// encrypted data, and is detected by the absence of the magic bytes.
+
+var (
+	encMagic  = []byte("SBOSENC")
+	encHeader = append(append([]byte{}, encMagic...), encVersion)
+)
+
+const (
+	encVersion     = 1
+	encHeaderSize  = 8
+	encDataKeySize = 32
+	encSegmentSize = 64 * 1024
 )
 
 func encryptReader(
-	encKey []byte,
+	keys *Keyring,
 	rRaw io.Reader,
 ) (
 	io.Reader,
+	map[string]string,
 	error,
 ) {
-	block, err := aes.NewCipher(encKey)
+	aead, meta, err := newDataKey(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return io.MultiReader(bytes.NewReader(encHeader), &encReader{
+		aead:   aead,
+		src:    bufio.NewReader(rRaw),
+		plain:  make([]byte, encSegmentSize),
+		sealed: make([]byte, 0, encSegmentSize+aead.Overhead()),
+	}), meta, nil
+}
+
+// encryptSection returns the data of src encrypted like encryptReader, as a
+// section that can also be read at any offset. Chunks are encrypted
+// independently, so that parts can be read, and encrypted, in parallel.
+func encryptSection(
+	keys *Keyring,
+	src *io.SectionReader,
+) (
+	*io.SectionReader,
+	map[string]string,
+	error,
+) {
+	aead, meta, err := newDataKey(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Empty data is encrypted as one empty chunk.
+	chunks := (src.Size() + encSegmentSize - 1) / encSegmentSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	size := encHeaderSize + src.Size() + chunks*int64(aead.Overhead())
+
+	return io.NewSectionReader(&encReaderAt{
+		aead:   aead,
+		src:    src,
+		chunks: chunks,
+		cache:  map[int64][]byte{},
+	}, 0, size), meta, nil
+}
+
+// decryptReader decrypts the object read from rRaw, given its metadata.
+func decryptReader(
+	keys *Keyring,
+	meta map[string]string,
+	rRaw io.Reader,
+) (
+	io.Reader,
+	error,
+) {
+	src := bufio.NewReader(rRaw)
+
+	chunked, err := peekEncHeader(src)
 	if err != nil {
-		log.Printf("Failed to create AES block cipher: %v", err)
 		return nil, err
 	}
 
-	iv := make([]byte, block.BlockSize())
-	if _, err := rand.Read(iv); err != nil {
-		log.Printf("Failed to create IV: %v", err)
+	if !chunked {
+		encKey, err := keys.legacyKey()
+		if err != nil {
+			return nil, err
+		}
+		return decryptReaderCTR(encKey, src)
+	}
+
+	if _, err := src.Discard(encHeaderSize); err != nil {
 		return nil, err
 	}
 
-	ivReader := bytes.NewBuffer(iv)
+	aead, err := openDataKey(keys, meta)
+	if err != nil {
+		return nil, err
+	}
 
-	sr := &cipher.StreamReader{
-		S: cipher.NewCTR(block, iv),
-		R: rRaw,
+	return &decReader{
+		aead:  aead,
+		src:   src,
+		chunk: make([]byte, encSegmentSize+aead.Overhead()),
+		final: -1,
+	}, nil
+}
+
+// decryptChunksReader decrypts the chunks of an object in the chunked format
+// read from rRaw, starting with chunk first. The metadata is the object's,
+// and final is the index of its final chunk.
+func decryptChunksReader(
+	keys *Keyring,
+	meta map[string]string,
+	rRaw io.Reader,
+	first,
+	final int64,
+) (
+	io.Reader,
+	error,
+) {
+	aead, err := openDataKey(keys, meta)
+	if err != nil {
+		return nil, err
 	}
 
-	return io.MultiReader(ivReader, sr), nil
+	return &decReader{
+		aead:  aead,
+		src:   bufio.NewReader(rRaw),
+		chunk: make([]byte, encSegmentSize+aead.Overhead()),
+		seq:   uint64(first),
+		final: final,
+	}, nil
+}
+
+// encLayout returns the number of chunks and the plaintext size of an object
+// in the chunked format with the given size.
+func encLayout(size int64) (chunks, plainSize int64) {
+	const overhead = 16 // Of AES-GCM.
+	body := size - encHeaderSize
+	chunks = (body + encSegmentSize + overhead - 1) / (encSegmentSize + overhead)
+	return chunks, body - chunks*overhead
 }
 
-func decryptReader(
+// peekEncHeader returns true if r starts with the header of the chunked
+// format, without consuming it. For objects in the legacy format, false is
+// returned.
+func peekEncHeader(r *bufio.Reader) (bool, error) {
+	buf, err := r.Peek(encHeaderSize)
+	if err != nil && err != io.EOF {
+		log.Printf("Failed to read encryption header: %v", err)
+		return false, err
+	}
+
+	if !bytes.HasPrefix(buf, encMagic) {
+		return false, nil
+	}
+	if len(buf) < encHeaderSize || buf[len(encMagic)] != encVersion {
+		log.Printf("Unknown encryption format version")
+		return false, ErrDecryptFailed
+	}
+	return true, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// newDataKey returns the cipher for a new object with a random data key,
+// along with the metadata storing the data key.
+func newDataKey(keys *Keyring) (cipher.AEAD, map[string]string, error) {
+	dataKey := make([]byte, encDataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		log.Printf("Failed to create data key: %v", err)
+		return nil, nil, err
+	}
+
+	meta, err := wrapDataKey(keys, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	return aead, meta, err
+}
+
+// openDataKey returns the cipher for an object with the given metadata.
+func openDataKey(keys *Keyring, meta map[string]string) (cipher.AEAD, error) {
+	dataKey, err := unwrapDataKey(keys, meta)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(dataKey)
+}
+
+// wrapDataKey returns the metadata storing dataKey, wrapped with the keyring's
+// primary key.
+func wrapDataKey(keys *Keyring, dataKey []byte) (map[string]string, error) {
+	keyID, encKey, err := keys.primaryKey()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		log.Printf("Failed to create nonce: %v", err)
+		return nil, err
+	}
+
+	// The key ID is authenticated with the data key.
+	wrapped := aead.Seal(nonce, nonce, dataKey, dataKeyAAD(keyID))
+
+	return map[string]string{
+		metaEncKeyID:   keyID,
+		metaEncDataKey: base64.StdEncoding.EncodeToString(wrapped),
+	}, nil
+}
+
+// unwrapDataKey returns the data key stored in the metadata of an object.
+func unwrapDataKey(keys *Keyring, meta map[string]string) ([]byte, error) {
+	keyID, ok := meta[metaEncKeyID]
+	wrapped, err := base64.StdEncoding.DecodeString(meta[metaEncDataKey])
+	if !ok || err != nil || len(wrapped) < 12 {
+		log.Printf("Missing or invalid data key in metadata")
+		return nil, ErrDecryptFailed
+	}
+
+	encKey, err := keys.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	dataKey, err := aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], dataKeyAAD(keyID))
+	if err != nil {
+		log.Printf("Failed to unwrap data key: %v", err)
+		return nil, ErrDecryptFailed
+	}
+	return dataKey, nil
+}
+
+// rewrapMeta returns a copy of the metadata of an object in the chunked
+// format with its data key wrapped with the keyring's primary key.
+func rewrapMeta(keys *Keyring, meta map[string]string) (map[string]string, error) {
+	dataKey, err := unwrapDataKey(keys, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrapDataKey(keys, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrapped := map[string]string{}
+	for key, val := range meta {
+		rewrapped[key] = val
+	}
+	for key, val := range wrapped {
+		rewrapped[key] = val
+	}
+	return rewrapped, nil
+}
+
+// dataKeyAAD returns the additional data authenticated with a data key
+// wrapped with the key keyID.
+func dataKeyAAD(keyID string) []byte {
+	return append(append([]byte{}, encHeader...), keyID...)
+}
+
+// ----------------------------------------------------------------------------
+
+// decryptReaderCTR reads objects written in the legacy, unauthenticated
+// AES-CTR format.
+func decryptReaderCTR(
 	encKey []byte,
 	rRaw io.Reader,
 ) (
@@ -52,7 +326,7 @@ func decryptReader(
 	}
 
 	iv := make([]byte, block.BlockSize())
-	if _, err := rRaw.Read(iv); err != nil {
+	if _, err := io.ReadFull(rRaw, iv); err != nil {
 		log.Printf("Failed to read IV: %v", err)
 		return nil, err
 	}
@@ -62,3 +336,273 @@ func decryptReader(
 		R: rRaw,
 	}, nil
 }
+
+// decryptReaderCTRAt is like decryptReaderCTR for the data read from rRaw
+// starting at offset of the decrypted data, given the object's IV.
+func decryptReaderCTRAt(
+	encKey,
+	iv []byte,
+	rRaw io.Reader,
+	offset int64,
+) (
+	io.Reader,
+	error,
+) {
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		log.Printf("Failed to create AES block cipher: %v", err)
+		return nil, err
+	}
+
+	// The counter is the IV incremented once per block, as a big endian
+	// number.
+	counter := append([]byte{}, iv...)
+	carry := uint64(offset) / uint64(block.BlockSize())
+	for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + carry&0xff
+		counter[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+
+	stream := cipher.NewCTR(block, counter)
+
+	skip := make([]byte, offset%int64(block.BlockSize()))
+	stream.XORKeyStream(skip, skip)
+
+	return &cipher.StreamReader{
+		S: stream,
+		R: rRaw,
+	}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Printf("Failed to create AES block cipher: %v", err)
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Printf("Failed to create AES-GCM cipher: %v", err)
+		return nil, err
+	}
+	return aead, nil
+}
+
+func segmentNonce(nonce []byte, seq uint64, final bool) {
+	binary.BigEndian.PutUint64(nonce, seq)
+	nonce[len(nonce)-1] = 0
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+type encReader struct {
+	aead   cipher.AEAD
+	src    *bufio.Reader
+	plain  []byte
+	sealed []byte
+	out    []byte
+	seq    uint64
+	done   bool
+	err    error
+}
+
+func (r *encReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.nextChunk()
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encReader) nextChunk() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	switch err {
+	case nil:
+		// A full segment: it's the final one if nothing follows it.
+		if _, err := r.src.Peek(1); err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		r.done = true
+	default:
+		return err
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	segmentNonce(nonce, r.seq, r.done)
+	r.seq++
+
+	r.out = r.aead.Seal(r.sealed[:0], nonce, r.plain[:n], encHeader)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// encReaderAtCache is the number of encrypted chunks kept by encReaderAt, so
+// that reading a chunk in several pieces doesn't encrypt it each time.
+const encReaderAtCache = 32
+
+type encReaderAt struct {
+	aead   cipher.AEAD
+	src    *io.SectionReader
+	chunks int64
+
+	mu    sync.Mutex
+	cache map[int64][]byte
+}
+
+func (r *encReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+
+	if off < encHeaderSize {
+		n = copy(p, encHeader[off:])
+		off += int64(n)
+	}
+
+	chunkSize := int64(encSegmentSize + r.aead.Overhead())
+
+	for n < len(p) {
+		seq := (off - encHeaderSize) / chunkSize
+		if seq >= r.chunks {
+			return n, io.EOF
+		}
+
+		sealed, err := r.chunk(seq)
+		if err != nil {
+			return n, err
+		}
+
+		within := (off - encHeaderSize) % chunkSize
+		m := copy(p[n:], sealed[within:])
+		n += m
+		off += int64(m)
+	}
+
+	return n, nil
+}
+
+// chunk returns the encrypted chunk seq.
+func (r *encReaderAt) chunk(seq int64) ([]byte, error) {
+	r.mu.Lock()
+	sealed, ok := r.cache[seq]
+	r.mu.Unlock()
+	if ok {
+		return sealed, nil
+	}
+
+	size := r.src.Size() - seq*encSegmentSize
+	if size > encSegmentSize {
+		size = encSegmentSize
+	}
+
+	plain := make([]byte, size)
+	n, err := r.src.ReadAt(plain, seq*encSegmentSize)
+	if err == io.EOF && int64(n) < size {
+		// The source shrank.
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		log.Printf("Failed to read chunk %d: %v", seq, err)
+		return nil, err
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	segmentNonce(nonce, uint64(seq), seq == r.chunks-1)
+	sealed = r.aead.Seal(nil, nonce, plain, encHeader)
+
+	r.mu.Lock()
+	if len(r.cache) >= encReaderAtCache {
+		for key := range r.cache {
+			delete(r.cache, key)
+			break
+		}
+	}
+	r.cache[seq] = sealed
+	r.mu.Unlock()
+
+	return sealed, nil
+}
+
+// ----------------------------------------------------------------------------
+
+type decReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	chunk []byte
+	out   []byte
+	seq   uint64
+	final int64 // Index of the final chunk, or -1 if it ends src.
+	done  bool
+	err   error
+}
+
+func (r *decReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.nextChunk()
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decReader) nextChunk() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	switch err {
+	case nil:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		r.done = true
+	case io.EOF:
+		// The stream ended without a final chunk.
+		log.Printf("Encrypted object is truncated")
+		return ErrDecryptFailed
+	default:
+		return err
+	}
+
+	final := r.done
+	if r.final >= 0 {
+		final = r.seq == uint64(r.final)
+		r.done = r.done || final
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	segmentNonce(nonce, r.seq, final)
+	r.seq++
+
+	out, err := r.aead.Open(r.chunk[:0], nonce, r.chunk[:n], encHeader)
+	if err != nil {
+		log.Printf("Failed to decrypt chunk %d: %v", r.seq-1, err)
+		return ErrDecryptFailed
+	}
+	r.out = out
+	return nil
+}
@@ -0,0 +1,314 @@
+package objstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+var testKeyring = &Keyring{
+	Keys: map[string][]byte{"": testEncKey},
+}
+
+const testEncHeaderSize = 8
+
+func encryptBytesForTesting(
+	t *testing.T,
+	keys *Keyring,
+	plain []byte,
+) (
+	[]byte,
+	map[string]string,
+) {
+	r, meta, err := encryptReader(keys, bytes.NewBuffer(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf, meta
+}
+
+func decryptBytesForTesting(
+	keys *Keyring,
+	meta map[string]string,
+	buf []byte,
+) (
+	[]byte,
+	error,
+) {
+	r, err := decryptReader(keys, meta, bytes.NewBuffer(buf))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestCryptoStreamRoundTrip(t *testing.T) {
+	sizes := []int{
+		0,
+		1,
+		encSegmentSize - 1,
+		encSegmentSize,
+		encSegmentSize + 1,
+		3 * encSegmentSize,
+	}
+
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		enc, meta := encryptBytesForTesting(t, testKeyring, plain)
+		out, err := decryptBytesForTesting(testKeyring, meta, enc)
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		if !bytes.Equal(plain, out) {
+			t.Fatal(size)
+		}
+	}
+}
+
+func TestCryptoStreamTampering(t *testing.T) {
+	plain := make([]byte, 3*encSegmentSize+100)
+	rand.Read(plain)
+	enc, meta := encryptBytesForTesting(t, testKeyring, plain)
+
+	chunkSize := encSegmentSize + 16
+
+	flipped := append([]byte{}, enc...)
+	flipped[testEncHeaderSize+chunkSize+7] ^= 1
+
+	flippedHeader := append([]byte{}, enc...)
+	flippedHeader[7] ^= 1
+
+	// Drop whole chunks from the end.
+	truncated := enc[:testEncHeaderSize+2*chunkSize]
+
+	// Swap the first two chunks.
+	reordered := append([]byte{}, enc[:testEncHeaderSize]...)
+	reordered = append(reordered, enc[testEncHeaderSize+chunkSize:testEncHeaderSize+2*chunkSize]...)
+	reordered = append(reordered, enc[testEncHeaderSize:testEncHeaderSize+chunkSize]...)
+	reordered = append(reordered, enc[testEncHeaderSize+2*chunkSize:]...)
+
+	// Append data after the final chunk.
+	extended := append(append([]byte{}, enc...), enc[testEncHeaderSize:testEncHeaderSize+chunkSize]...)
+
+	for name, buf := range map[string][]byte{
+		"flipped":        flipped,
+		"flipped header": flippedHeader,
+		"truncated":      truncated,
+		"reordered":      reordered,
+		"extended":       extended,
+	} {
+		if _, err := decryptBytesForTesting(testKeyring, meta, buf); err != ErrDecryptFailed {
+			t.Fatal(name, err)
+		}
+	}
+
+	// The data key of another object.
+	_, otherMeta := encryptBytesForTesting(t, testKeyring, plain)
+	if _, err := decryptBytesForTesting(testKeyring, otherMeta, enc); err != ErrDecryptFailed {
+		t.Fatal(err)
+	}
+
+	// A data key claiming to be wrapped with another key.
+	tampered := map[string]string{}
+	for key, val := range meta {
+		tampered[key] = val
+	}
+	tampered[metaEncKeyID] = "other"
+	keys := &Keyring{
+		Keys: map[string][]byte{"": testEncKey, "other": testEncKey},
+	}
+	if _, err := decryptBytesForTesting(keys, tampered, enc); err != ErrDecryptFailed {
+		t.Fatal(err)
+	}
+
+	// No data key.
+	if _, err := decryptBytesForTesting(testKeyring, nil, enc); err != ErrDecryptFailed {
+		t.Fatal(err)
+	}
+}
+
+func TestCryptoStreamLegacyCTR(t *testing.T) {
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+
+	block, err := aes.NewCipher(testEncKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, block.BlockSize())
+	rand.Read(iv)
+
+	enc := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(enc, plain)
+
+	out, err := decryptBytesForTesting(testKeyring, nil, append(iv, enc...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, out) {
+		t.Fatal("legacy decryption failed")
+	}
+}
+
+func TestCryptoStreamKeyring(t *testing.T) {
+	keyA := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	keyB := []byte("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+
+	plain := []byte("Some data.")
+
+	enc, meta := encryptBytesForTesting(t, &Keyring{
+		Primary: "a",
+		Keys:    map[string][]byte{"a": keyA},
+	}, plain)
+
+	// After rotation, the old key is still used to decrypt.
+	out, err := decryptBytesForTesting(&Keyring{
+		Primary: "b",
+		Keys:    map[string][]byte{"a": keyA, "b": keyB},
+	}, meta, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, out) {
+		t.Fatal(string(out))
+	}
+
+	// Once removed, the old key's objects can't be read.
+	_, err = decryptBytesForTesting(&Keyring{
+		Primary: "b",
+		Keys:    map[string][]byte{"b": keyB},
+	}, meta, enc)
+	if err != ErrKeyNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestCryptoStreamRewrap(t *testing.T) {
+	keyA := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	keyB := []byte("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+
+	plain := make([]byte, 2*encSegmentSize)
+	rand.Read(plain)
+
+	enc, meta := encryptBytesForTesting(t, &Keyring{
+		Primary: "a",
+		Keys:    map[string][]byte{"a": keyA},
+	}, plain)
+	meta["Other"] = "kept"
+
+	rewrapped, err := rewrapMeta(&Keyring{
+		Primary: "b",
+		Keys:    map[string][]byte{"a": keyA, "b": keyB},
+	}, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped[metaEncKeyID] != "b" || rewrapped["Other"] != "kept" || meta[metaEncKeyID] != "a" {
+		t.Fatal(rewrapped, meta)
+	}
+
+	// Only the metadata changes.
+	out, err := decryptBytesForTesting(&Keyring{
+		Primary: "b",
+		Keys:    map[string][]byte{"b": keyB},
+	}, rewrapped, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, out) {
+		t.Fatal("rewrapped decryption failed")
+	}
+}
+
+func TestCryptoStreamSection(t *testing.T) {
+	for _, size := range []int{0, 1, encSegmentSize, encSegmentSize + 1, 3*encSegmentSize - 5} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		sr, meta, err := encryptSection(testKeyring, io.NewSectionReader(bytes.NewReader(plain), 0, int64(size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Read the parts in parallel, in pieces not aligned with chunks.
+		enc := make([]byte, sr.Size())
+		partSize := int64(encSegmentSize/2 + 7)
+
+		var wg sync.WaitGroup
+		for offset := int64(0); offset < sr.Size(); offset += partSize {
+			wg.Add(1)
+			go func(offset int64) {
+				defer wg.Done()
+				for i := offset; i < offset+partSize && i < sr.Size(); i += 1000 {
+					end := i + 1000
+					if end > offset+partSize {
+						end = offset + partSize
+					}
+					if end > sr.Size() {
+						end = sr.Size()
+					}
+					if _, err := sr.ReadAt(enc[i:end], i); err != nil && err != io.EOF {
+						t.Error(err)
+					}
+				}
+			}(offset)
+		}
+		wg.Wait()
+
+		out, err := decryptBytesForTesting(testKeyring, meta, enc)
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		if !bytes.Equal(plain, out) {
+			t.Fatal(size)
+		}
+
+		// Read sequentially, it's the same.
+		seq, err := ioutil.ReadAll(sr)
+		if err != nil || !bytes.Equal(seq, enc) {
+			t.Fatal(size, err)
+		}
+	}
+}
+
+func TestCryptoStreamLegacyCTRAt(t *testing.T) {
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+
+	block, err := aes.NewCipher(testEncKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Incrementing the counter carries over several bytes.
+	iv := bytes.Repeat([]byte{0xff}, block.BlockSize())
+	iv[0] = 0x12
+
+	enc := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(enc, plain)
+
+	for _, offset := range []int{0, 1, 15, 16, 17, 500, 999} {
+		r, err := decryptReaderCTRAt(testEncKey, iv, bytes.NewReader(enc[offset:]), int64(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain[offset:], out) {
+			t.Fatal(offset)
+		}
+	}
+}
@@ -0,0 +1,670 @@
+package objstore
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PutDirOptions configures PutDir.
+type PutDirOptions struct {
+	PutOptions
+
+	// Include, if set, limits the archived files and symlinks to those
+	// matching one of the patterns. Directories are archived if they contain
+	// an included entry.
+	Include []string
+
+	// Exclude skips the entries matching one of the patterns. The contents of
+	// excluded directories are skipped as well.
+	Exclude []string
+
+	// Index, if set, compresses each entry separately and writes an index of
+	// the archive to rPath+DirIndexSuffix, so that the archive can be listed
+	// with ListDir and single files read with GetDirFile without reading the
+	// whole archive. The codec must be able to decompress concatenated
+	// streams, as gzip, zstd and s2 can.
+	Index bool
+}
+
+// GetDirOptions configures GetDir.
+type GetDirOptions struct {
+	GetOptions
+
+	// MaxSize limits the total size of the extracted files. Default:
+	// DefaultMaxDirSize. Negative values disable the limit.
+	MaxSize int64
+
+	// MaxFiles limits the number of extracted entries, including
+	// directories. Default: DefaultMaxDirFiles. Negative values disable the
+	// limit.
+	MaxFiles int
+}
+
+// Default limits of GetDirOptions.
+const (
+	DefaultMaxDirSize  = 64 << 30
+	DefaultMaxDirFiles = 1 << 20
+)
+
+func (opts GetDirOptions) maxSize() int64 {
+	if opts.MaxSize == 0 {
+		return DefaultMaxDirSize
+	}
+	return opts.MaxSize
+}
+
+func (opts GetDirOptions) maxFiles() int {
+	if opts.MaxFiles == 0 {
+		return DefaultMaxDirFiles
+	}
+	return opts.MaxFiles
+}
+
+// ----------------------------------------------------------------------------
+
+// PutDir writes the directory tree below lPath to bucket/rPath as a tar
+// archive. Directories, regular files and symlinks are archived along with
+// their modes and modification times, in sorted order so that archiving the
+// same tree twice gives the same archive. Other file types are skipped.
+//
+// Patterns use the syntax of path.Match, and are matched against the
+// slash-separated path relative to lPath, or against the base name if they
+// don't contain a slash.
+func (cl *Client) PutDir(
+	ctx context.Context,
+	lPath,
+	bucket,
+	rPath string,
+	opts PutDirOptions,
+) error {
+	if err := checkPatterns(opts.Include, opts.Exclude); err != nil {
+		return err
+	}
+
+	if opts.ContentType == "" {
+		opts.ContentType = "application/x-tar"
+	}
+
+	return cl.withRetryPolicy(ctx, opts.Retry, func() error {
+		return cl.putDir(ctx, lPath, bucket, rPath, opts)
+	})
+}
+
+func (cl *Client) putDir(
+	ctx context.Context,
+	lPath,
+	bucket,
+	rPath string,
+	opts PutDirOptions,
+) error {
+	entries, err := dirEntries(lPath, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Index {
+		return cl.putDirIndexed(ctx, entries, bucket, rPath, opts)
+	}
+
+	r, w := io.Pipe()
+
+	// Closing the reader stops the goroutine if the upload fails or is
+	// cancelled before the whole archive has been read.
+	defer r.Close()
+
+	go func() {
+		w.CloseWithError(writeTar(ctx, w, entries, nil))
+	}()
+
+	return cl.putObject(ctx, r, bucket, rPath, "", nil, opts.PutOptions)
+}
+
+type dirEntry struct {
+	path string // Local path.
+	name string // Slash-separated path relative to the archived directory.
+	info os.FileInfo
+}
+
+// dirEntries returns the entries below lPath to archive, in sorted order.
+func dirEntries(lPath string, opts PutDirOptions) ([]dirEntry, error) {
+	var entries []dirEntry
+
+	// filepath.Walk visits entries in lexical order.
+	err := filepath.Walk(lPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == lPath {
+			return nil
+		}
+
+		rel, err := filepath.Rel(lPath, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		if matchAny(opts.Exclude, name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+		case mode.IsRegular(), mode&os.ModeSymlink != 0:
+			if len(opts.Include) > 0 && !matchAny(opts.Include, name) {
+				return nil
+			}
+		default:
+			log.Printf("Skipping %s: unsupported file type %v", p, mode.Type())
+			return nil
+		}
+
+		entries = append(entries, dirEntry{path: p, name: name, info: info})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to list local files: %v", err)
+		return nil, err
+	}
+
+	if len(opts.Include) == 0 {
+		return entries, nil
+	}
+
+	// Keep the directories containing included entries. Entries are sorted,
+	// so a directory's descendants follow it.
+	keep := map[string]bool{}
+	for _, e := range entries {
+		if e.info.IsDir() {
+			continue
+		}
+		for dir := path.Dir(e.name); dir != "."; dir = path.Dir(dir) {
+			keep[dir] = true
+		}
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if !e.info.IsDir() || keep[e.name] {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+// checkPatterns checks the syntax of the given include and exclude patterns.
+func checkPatterns(include, exclude []string) error {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Printf("Invalid pattern %s: %v", pattern, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// matchAny reports whether name matches one of the patterns, see PutDir.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTar writes the entries to w as a tar archive. If onEntry is given,
+// it's called after each entry has been written in full, with the entry's
+// header and, for regular files, the SHA-256 checksum of its contents.
+func writeTar(
+	ctx context.Context,
+	w io.Writer,
+	entries []dirEntry,
+	onEntry func(header *tar.Header, sum string) error,
+) error {
+	tw := tar.NewWriter(w)
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var link string
+		if e.info.Mode()&os.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(e.path); err != nil {
+				log.Printf("Failed to read symlink %s: %v", e.path, err)
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(e.info, link)
+		if err != nil {
+			log.Printf("Failed to create tar header for %s: %v", e.path, err)
+			return err
+		}
+
+		// Leave out what differs between machines, so that archives are
+		// reproducible.
+		header.Name = e.name
+		if e.info.IsDir() {
+			header.Name += "/"
+		}
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+
+		if err := tw.WriteHeader(header); err != nil {
+			log.Printf("Failed to write tar header: %v", err)
+			return err
+		}
+
+		var sum string
+		if header.Typeflag == tar.TypeReg {
+			if sum, err = writeTarFile(tw, e.path, header.Size); err != nil {
+				return err
+			}
+		}
+
+		if onEntry != nil {
+			// Write the padding, so the entry is complete.
+			if err := tw.Flush(); err != nil {
+				log.Printf("Failed to write tar archive: %v", err)
+				return err
+			}
+			if err := onEntry(header, sum); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, lPath string, size int64) (string, error) {
+	f, err := os.Open(lPath)
+	if err != nil {
+		log.Printf("Failed to open file %s: %v", lPath, err)
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	// Copy exactly the size in the header, in case the file has changed.
+	if _, err := io.CopyN(io.MultiWriter(tw, h), f, size); err != nil {
+		log.Printf("Failed to write file to tar archive: %v", err)
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ----------------------------------------------------------------------------
+
+// GetDir restores the directory tree archived by PutDir at bucket/rPath into
+// lPath, replacing it. Modes and modification times are restored, except for
+// the modification times of symlinks.
+//
+// The archive is extracted into a temporary directory next to lPath, which
+// replaces lPath only once the whole archive has been extracted and verified.
+// Archives with entries outside lPath, device files or hard links are
+// rejected with ErrUnsafeArchive, and archives exceeding the limits in opts
+// with ErrArchiveTooLarge.
+func (cl *Client) GetDir(
+	ctx context.Context,
+	bucket,
+	rPath,
+	lPath string,
+	opts GetDirOptions,
+) error {
+	return cl.withRetryPolicy(ctx, opts.Retry, func() error {
+		return cl.getDir(ctx, bucket, rPath, lPath, opts)
+	})
+}
+
+func (cl *Client) getDir(
+	ctx context.Context,
+	bucket,
+	rPath,
+	lPath string,
+	opts GetDirOptions,
+) error {
+	f, err := cl.getObject(ctx, bucket, rPath, opts.GetOptions)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lPath = filepath.Clean(lPath)
+	parent := filepath.Dir(lPath)
+
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		log.Printf("Failed to create local directory %s: %v", parent, err)
+		return err
+	}
+
+	tmpPath, err := ioutil.TempDir(parent, "."+filepath.Base(lPath)+".tmp-")
+	if err != nil {
+		log.Printf("Failed to create temporary directory: %v", err)
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	if err := extractTar(f, tmpPath, opts); err != nil {
+		return err
+	}
+
+	// Read to the end so the checksum is verified.
+	if _, err := io.Copy(ioutil.Discard, f); err != nil {
+		log.Printf("Failed to read to the end of the archive: %v", err)
+		return err
+	}
+
+	return replaceDir(tmpPath, lPath)
+}
+
+// replaceDir moves the directory src to dst, replacing dst if it exists.
+func replaceDir(src, dst string) error {
+	old := ""
+	if _, err := os.Lstat(dst); err == nil {
+		old = src + ".old"
+		if err := os.Rename(dst, old); err != nil {
+			log.Printf("Failed to move %s out of the way: %v", dst, err)
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Failed to stat %s: %v", dst, err)
+		return err
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		log.Printf("Failed to move %s to %s: %v", src, dst, err)
+		if old != "" {
+			os.Rename(old, dst)
+		}
+		return err
+	}
+
+	if old != "" {
+		if err := os.RemoveAll(old); err != nil {
+			log.Printf("Failed to remove %s: %v", old, err)
+		}
+	}
+	return nil
+}
+
+// extractor extracts a tar archive into a directory, rejecting unsafe
+// entries.
+type extractor struct {
+	root string
+	opts GetDirOptions
+
+	size  int64
+	files int
+
+	// Symlinks extracted so far, with their targets. Entries below them are
+	// rejected, as they could be written anywhere.
+	symlinks map[string]string
+
+	// Paths passed while resolving the targets of the symlinks.
+	resolved map[string]bool
+
+	// Directories are created writable, and get their mode and modification
+	// time once their contents have been extracted.
+	dirs []*tar.Header
+}
+
+func extractTar(r io.Reader, root string, opts GetDirOptions) error {
+	x := &extractor{
+		root:     root,
+		opts:     opts,
+		symlinks: map[string]string{},
+		resolved: map[string]bool{},
+	}
+
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to read tar header: %v", err)
+			return err
+		}
+
+		if err := x.extract(tr, header); err != nil {
+			return err
+		}
+	}
+
+	return x.finish()
+}
+
+func (x *extractor) extract(r io.Reader, header *tar.Header) error {
+	name, err := x.checkName(header)
+	if err != nil {
+		return err
+	}
+
+	x.files++
+	x.size += header.Size
+	if x.opts.MaxFiles >= 0 && x.files > x.opts.maxFiles() ||
+		x.opts.MaxSize >= 0 && x.size > x.opts.maxSize() {
+		log.Printf("Archive exceeds limits at %s", header.Name)
+		return ErrArchiveTooLarge
+	}
+
+	dstPath := filepath.Join(x.root, filepath.FromSlash(name))
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(dstPath, 0700); err != nil {
+			log.Printf("Failed to create directory %s: %v", dstPath, err)
+			return err
+		}
+		header.Name = name
+		x.dirs = append(x.dirs, header)
+
+	case tar.TypeReg:
+		if err := removeNonDir(dstPath); err != nil {
+			return err
+		}
+		if err := extractTarFile(r, dstPath, header); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := x.checkSymlink(name, header.Linkname); err != nil {
+			return err
+		}
+		if err := removeNonDir(dstPath); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0700); err != nil {
+			log.Printf("Failed to create directory for %s: %v", dstPath, err)
+			return err
+		}
+		if err := os.Symlink(header.Linkname, dstPath); err != nil {
+			log.Printf("Failed to create symlink %s: %v", dstPath, err)
+			return err
+		}
+
+	case tar.TypeLink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		log.Printf("Rejecting %s: unsupported type %c", header.Name, header.Typeflag)
+		return ErrUnsafeArchive
+
+	default:
+		log.Printf("Skipping %s: unsupported type %c", header.Name, header.Typeflag)
+	}
+
+	return nil
+}
+
+// checkName returns the cleaned name of the entry, checking that it's inside
+// the root and not below an extracted symlink.
+func (x *extractor) checkName(header *tar.Header) (string, error) {
+	name := path.Clean(strings.TrimSuffix(header.Name, "/"))
+
+	if path.IsAbs(header.Name) || name == "." || name == ".." ||
+		strings.HasPrefix(name, "../") || strings.Contains(header.Name, "\\") {
+		log.Printf("Rejecting %s: path outside of target", header.Name)
+		return "", ErrUnsafeArchive
+	}
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := x.symlinks[dir]; ok {
+			log.Printf("Rejecting %s: path below symlink", header.Name)
+			return "", ErrUnsafeArchive
+		}
+	}
+
+	return name, nil
+}
+
+func (x *extractor) finish() error {
+	// Deepest first, so that setting a directory's attributes doesn't
+	// interfere with its parent.
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		header := x.dirs[i]
+		dstPath := filepath.Join(x.root, filepath.FromSlash(header.Name))
+
+		if err := os.Chmod(dstPath, os.FileMode(header.Mode).Perm()); err != nil {
+			log.Printf("Failed to set mode of %s: %v", dstPath, err)
+			return err
+		}
+		if err := os.Chtimes(dstPath, header.ModTime, header.ModTime); err != nil {
+			log.Printf("Failed to set modification time of %s: %v", dstPath, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkSymlink checks that the symlink name pointing to target stays inside
+// the root when resolved through the symlinks extracted before, and records
+// it. If an earlier symlink was resolved through name, the earlier symlinks
+// are checked again, as they now resolve differently.
+func (x *extractor) checkSymlink(name, target string) error {
+	links := map[string]string{name: target}
+	if x.resolved[name] {
+		links = x.symlinks
+	}
+	x.symlinks[name] = target
+
+	for name, target := range links {
+		if _, err := x.resolveSymlink(name, target, 0); err != nil {
+			log.Printf("Rejecting symlink %s: target %s outside of target", name, target)
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSymlink returns the path relative to the root that the symlink name
+// pointing to target resolves to, following the extracted symlinks. It fails
+// if the path leaves the root at any point, and for too many nested symlinks.
+func (x *extractor) resolveSymlink(name, target string, depth int) (string, error) {
+	if path.IsAbs(target) || depth > 40 {
+		return "", ErrUnsafeArchive
+	}
+
+	resolved := path.Dir(name)
+	for _, elem := range strings.Split(target, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "." {
+				return "", ErrUnsafeArchive
+			}
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		resolved = path.Join(resolved, elem)
+		x.resolved[resolved] = true
+
+		if linkTarget, ok := x.symlinks[resolved]; ok {
+			var err error
+			if resolved, err = x.resolveSymlink(resolved, linkTarget, depth+1); err != nil {
+				return "", err
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// removeNonDir removes what's at path unless it's a directory, so that a
+// later entry with the same name replaces an earlier one instead of being
+// written through a symlink.
+func removeNonDir(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("Failed to remove %s: %v", path, err)
+		return err
+	}
+	return nil
+}
+
+func extractTarFile(r io.Reader, dstPath string, header *tar.Header) error {
+	// Old archives don't have entries for parent directories.
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0700); err != nil {
+		log.Printf("Failed to create directory for %s: %v", dstPath, err)
+		return err
+	}
+
+	mode := os.FileMode(header.Mode).Perm()
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		log.Printf("Failed to create file %s: %v", dstPath, err)
+		return err
+	}
+
+	if _, err := io.CopyN(dst, r, header.Size); err != nil {
+		dst.Close()
+		log.Printf("Failed to write file %s: %v", dstPath, err)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		log.Printf("Failed to close file %s: %v", dstPath, err)
+		return err
+	}
+
+	// The mode given on creation is subject to the umask.
+	if err := os.Chmod(dstPath, mode); err != nil {
+		log.Printf("Failed to set mode of %s: %v", dstPath, err)
+		return err
+	}
+
+	if err := os.Chtimes(dstPath, header.ModTime, header.ModTime); err != nil {
+		log.Printf("Failed to set modification time of %s: %v", dstPath, err)
+		return err
+	}
+
+	return nil
+}
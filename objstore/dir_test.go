@@ -0,0 +1,328 @@
+package objstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeTestTree creates a directory tree with nested and empty directories,
+// a symlink and files with different modes below root.
+func makeTestTree(t *testing.T, root string) time.Time {
+	mtime := time.Date(2020, 4, 29, 12, 0, 0, 0, time.UTC)
+
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(os.MkdirAll(filepath.Join(root, "a/b"), 0755))
+	must(os.MkdirAll(filepath.Join(root, "a/empty"), 0750))
+	must(ioutil.WriteFile(filepath.Join(root, "a/b/file.txt"), []byte("file"), 0640))
+	must(ioutil.WriteFile(filepath.Join(root, "top.log"), []byte("log"), 0600))
+	must(os.Symlink("a/b/file.txt", filepath.Join(root, "link")))
+
+	for _, name := range []string{"a/b/file.txt", "top.log", "a/empty", "a/b", "a"} {
+		must(os.Chtimes(filepath.Join(root, name), mtime, mtime))
+	}
+	return mtime
+}
+
+func TestClientPutGetDir(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	mtime := makeTestTree(t, "files/out/src")
+
+	opts := PutDirOptions{
+		PutOptions: PutOptions{Codec: CodecGzip},
+		Exclude:    []string{"*.log"},
+	}
+	if err := cl.PutDir(ctx, "files/out/src", testBucket, "dir.tar.gz", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.GetDir(ctx, testBucket, "dir.tar.gz", "files/out/dst", GetDirOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	type TestCase struct {
+		Name string
+		Mode os.FileMode
+	}
+
+	cases := []TestCase{
+		{"a", os.ModeDir | 0755},
+		{"a/b", os.ModeDir | 0755},
+		{"a/empty", os.ModeDir | 0750},
+		{"a/b/file.txt", 0640},
+	}
+
+	for _, tc := range cases {
+		fi, err := os.Lstat(filepath.Join("files/out/dst", tc.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != tc.Mode || !fi.ModTime().Equal(mtime) {
+			t.Fatal(tc.Name, fi.Mode(), fi.ModTime())
+		}
+	}
+
+	if link, err := os.Readlink("files/out/dst/link"); err != nil || link != "a/b/file.txt" {
+		t.Fatal(link, err)
+	}
+	if _, err := os.Lstat("files/out/dst/top.log"); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	// Archiving the same tree again gives the same archive.
+	info, err := cl.Stat(testBucket, "dir.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutDir(ctx, "files/out/dst", testBucket, "dir2.tar.gz", opts); err != nil {
+		t.Fatal(err)
+	}
+	info2, err := cl.Stat(testBucket, "dir2.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Checksum != info2.Checksum {
+		t.Fatal(info.Checksum, info2.Checksum)
+	}
+}
+
+func TestClientPutDirInclude(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	makeTestTree(t, "files/out/src")
+
+	opts := PutDirOptions{Include: []string{"*.txt"}}
+	if err := cl.PutDir(ctx, "files/out/src", testBucket, "dir.tar", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.GetDir(ctx, testBucket, "dir.tar", "files/out/dst", GetDirOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat("files/out/dst/a/b/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/empty", "link", "top.log"} {
+		if _, err := os.Lstat(filepath.Join("files/out/dst", name)); !os.IsNotExist(err) {
+			t.Fatal(name, err)
+		}
+	}
+
+	opts = PutDirOptions{Include: []string{"[a-"}}
+	if err := cl.PutDir(ctx, "files/out/src", testBucket, "dir.tar", opts); err == nil {
+		t.Fatal(err)
+	}
+}
+
+// putTestTar writes a tar archive with the given headers to bucket/rPath.
+// Regular files are filled with zeros.
+func putTestTar(t *testing.T, cl *Client, rPath string, headers ...*tar.Header) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, h := range headers {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(make([]byte, h.Size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cl.PutObject(context.Background(), buf, testBucket, rPath, PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientGetDirUnsafe(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	file := func(name string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0600, Size: 1}
+	}
+	link := func(name, target string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target}
+	}
+
+	type TestCase struct {
+		Name    string
+		Headers []*tar.Header
+		Opts    GetDirOptions
+		Err     error
+	}
+
+	cases := []TestCase{
+		{"parent", []*tar.Header{file("../evil")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"nested-parent", []*tar.Header{file("a/../../evil")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"absolute", []*tar.Header{file("/tmp/evil")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"hard-link", []*tar.Header{
+			file("a"),
+			{Typeflag: tar.TypeLink, Name: "b", Linkname: "a"},
+		}, GetDirOptions{}, ErrUnsafeArchive},
+		{"device", []*tar.Header{
+			{Typeflag: tar.TypeChar, Name: "null", Mode: 0666, Devmajor: 1, Devminor: 3},
+		}, GetDirOptions{}, ErrUnsafeArchive},
+		{"fifo", []*tar.Header{
+			{Typeflag: tar.TypeFifo, Name: "fifo", Mode: 0600},
+		}, GetDirOptions{}, ErrUnsafeArchive},
+		{"symlink-absolute", []*tar.Header{link("l", "/etc")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"symlink-parent", []*tar.Header{link("a/l", "../../out")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"through-symlink", []*tar.Header{link("l", "."), file("l/x")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"symlink-chain", []*tar.Header{link("a/b", ".."), link("c", "a/b/..")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"symlink-chain-later", []*tar.Header{link("c", "a/b/.."), link("a/b", "..")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"symlink-loop", []*tar.Header{link("l", "l")}, GetDirOptions{}, ErrUnsafeArchive},
+		{"max-files", []*tar.Header{file("a"), file("b")}, GetDirOptions{MaxFiles: 1}, ErrArchiveTooLarge},
+		{"max-size", []*tar.Header{file("a"), file("b")}, GetDirOptions{MaxSize: 1}, ErrArchiveTooLarge},
+		{"within-limits", []*tar.Header{file("a"), file("b")}, GetDirOptions{MaxFiles: 2, MaxSize: 2}, nil},
+		{"unlimited", []*tar.Header{file("a"), file("b")}, GetDirOptions{MaxFiles: -1, MaxSize: -1}, nil},
+		{"symlink-inside", []*tar.Header{file("a/x"), link("a/l", "../a/x")}, GetDirOptions{}, nil},
+		{"symlink-chain-inside", []*tar.Header{link("a/b", "c/d"), link("e", "a/b/..")}, GetDirOptions{}, nil},
+	}
+
+	for _, tc := range cases {
+		dst := filepath.Join("files/out/unsafe", tc.Name)
+		if err := os.MkdirAll(dst, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dst, "keep"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		putTestTar(t, cl, "unsafe.tar", tc.Headers...)
+
+		err := cl.GetDir(ctx, testBucket, "unsafe.tar", dst, tc.Opts)
+		if !errors.Is(err, tc.Err) {
+			t.Fatal(tc.Name, err)
+		}
+
+		// The target is only replaced on success.
+		_, err = os.Stat(filepath.Join(dst, "keep"))
+		if (tc.Err == nil) != os.IsNotExist(err) {
+			t.Fatal(tc.Name, err)
+		}
+
+		// No temporary directories are left behind.
+		entries, err := ioutil.ReadDir("files/out/unsafe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Name()[0] == '.' {
+				t.Fatal(tc.Name, e.Name())
+			}
+		}
+	}
+
+	if _, err := os.Lstat("files/out/evil"); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestClientDirIndex(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	makeTestTree(t, "files/out/src")
+
+	// A file spanning several encrypted chunks.
+	big := make([]byte, 3*encSegmentSize)
+	rand.New(rand.NewSource(1)).Read(big)
+	if err := ioutil.WriteFile("files/out/src/a/big", big, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := PutDirOptions{
+		PutOptions: PutOptions{Codec: CodecGzip},
+		Index:      true,
+	}
+	if err := cl.PutDir(ctx, "files/out/src", testBucket, "dir.tar.gz", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := cl.ListDir(ctx, testBucket, "dir.tar.gz", GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	expected := []string{"a", "a/b", "a/b/file.txt", "a/big", "a/empty", "link", "top.log"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatal(names)
+	}
+	if entries[5].Mode&os.ModeSymlink == 0 || entries[5].Linkname != "a/b/file.txt" {
+		t.Fatal(entries[5])
+	}
+
+	type TestCase struct {
+		Member string
+		Data   []byte
+		Err    error
+	}
+
+	cases := []TestCase{
+		{"a/b/file.txt", []byte("file"), nil},
+		{"./a/big", big, nil},
+		{"top.log", []byte("log"), nil},
+		{"a/b", nil, ErrPathNotFound},
+		{"link", nil, ErrPathNotFound},
+		{"missing", nil, ErrPathNotFound},
+	}
+
+	for _, tc := range cases {
+		r, err := cl.GetDirFile(ctx, testBucket, "dir.tar.gz", tc.Member, GetOptions{})
+		if err != tc.Err {
+			t.Fatal(tc.Member, err)
+		}
+		if err != nil {
+			continue
+		}
+		buf, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(buf, tc.Data) {
+			t.Fatal(tc.Member, err)
+		}
+	}
+
+	// The indexed archive extracts like any other.
+	if err := cl.GetDir(ctx, testBucket, "dir.tar.gz", "files/out/dst", GetDirOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !pathsMatch("files/out/src/a/big", "files/out/dst/a/big") {
+		t.Fatal("big")
+	}
+
+	// Replacing the archive without an index makes the index stale.
+	opts.Index = false
+	opts.Exclude = []string{"big"}
+	if err := cl.PutDir(ctx, "files/out/src", testBucket, "dir.tar.gz", opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.ListDir(ctx, testBucket, "dir.tar.gz", GetOptions{}); err != ErrStaleIndex {
+		t.Fatal(err)
+	}
+	if _, err := cl.GetDirFile(ctx, testBucket, "dir.tar.gz", "top.log", GetOptions{}); err != ErrStaleIndex {
+		t.Fatal(err)
+	}
+}
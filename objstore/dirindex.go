@@ -0,0 +1,365 @@
+package objstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// DirIndexSuffix is appended to the path of an archive written by PutDir to
+// get the path of its index.
+const DirIndexSuffix = ".index"
+
+// ArchiveEntry describes an entry of an archive written by PutDir.
+type ArchiveEntry struct {
+	Name     string      // Slash-separated path relative to the archived directory.
+	Mode     os.FileMode // Including the type bits.
+	Size     int64
+	ModTime  time.Time
+	Linkname string `json:",omitempty"` // Target of symlinks.
+	Checksum string `json:",omitempty"` // SHA-256 of the contents of regular files.
+}
+
+// dirIndex is the index of an archive, stored as JSON.
+type dirIndex struct {
+	Version  int
+	Checksum string // Of the archive, to detect a stale index.
+	Codec    string
+	Entries  []dirIndexEntry
+}
+
+type dirIndexEntry struct {
+	ArchiveEntry
+
+	// The compressed member holding the entry, in the archive as stored
+	// before encryption.
+	Offset int64
+	Length int64
+}
+
+// ----------------------------------------------------------------------------
+
+// putDirIndexed writes the entries to bucket/rPath as an archive with one
+// compressed member per entry, followed by its index.
+func (cl *Client) putDirIndexed(
+	ctx context.Context,
+	entries []dirEntry,
+	bucket,
+	rPath string,
+	opts PutDirOptions,
+) error {
+	r, w := io.Pipe()
+
+	// Closing the reader stops the goroutine if the upload fails or is
+	// cancelled before the whole archive has been read.
+	defer r.Close()
+
+	mw, err := newMemberWriter(w, opts.Codec, opts.CodecLevel)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	index := dirIndex{Version: 1, Codec: opts.Codec}
+
+	go func() {
+		err := writeTar(ctx, io.MultiWriter(h, mw), entries, func(header *tar.Header, sum string) error {
+			offset, length, err := mw.next()
+			if err != nil {
+				return err
+			}
+			index.Entries = append(index.Entries, dirIndexEntry{
+				ArchiveEntry: ArchiveEntry{
+					Name:     strings.TrimSuffix(header.Name, "/"),
+					Mode:     header.FileInfo().Mode(),
+					Size:     header.Size,
+					ModTime:  header.ModTime,
+					Linkname: header.Linkname,
+					Checksum: sum,
+				},
+				Offset: offset,
+				Length: length,
+			})
+			return nil
+		})
+		if err == nil {
+			err = mw.Close()
+		}
+		w.CloseWithError(err)
+	}()
+
+	if err := cl.putCompressed(ctx, r, bucket, rPath, "", h, nil, opts.PutOptions); err != nil {
+		return err
+	}
+
+	index.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	buf, err := json.Marshal(index)
+	if err != nil {
+		log.Printf("Failed to encode archive index: %v", err)
+		return err
+	}
+
+	indexOpts := opts.PutOptions
+	indexOpts.ObjectMeta = ObjectMeta{ContentType: "application/json"}
+	indexSum := sha256.Sum256(buf)
+	return cl.putObject(ctx, bytes.NewReader(buf), bucket, rPath+DirIndexSuffix,
+		hex.EncodeToString(indexSum[:]), nil, indexOpts)
+}
+
+// memberWriter compresses the data written to it as a series of members,
+// each of which can be decompressed by itself. Concatenated, they decompress
+// to the whole.
+type memberWriter struct {
+	out    countingWriter
+	codec  Codec // Nil if not compressing.
+	level  int
+	cw     io.WriteCloser // The current member.
+	offset int64          // Of the current member in the output.
+}
+
+func newMemberWriter(w io.Writer, codec string, level int) (*memberWriter, error) {
+	mw := &memberWriter{
+		out:   countingWriter{w: w},
+		level: level,
+	}
+
+	if codec != "" {
+		var err error
+		if mw.codec, err = lookupCodec(codec); err != nil {
+			return nil, err
+		}
+	}
+
+	return mw, mw.start()
+}
+
+func (mw *memberWriter) start() error {
+	mw.offset = mw.out.n
+
+	if mw.codec == nil {
+		mw.cw = nopWriteCloser{&mw.out}
+		return nil
+	}
+
+	var err error
+	if mw.cw, err = mw.codec.NewWriter(&mw.out, mw.level); err != nil {
+		log.Printf("Failed to create compressor: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (mw *memberWriter) Write(p []byte) (int, error) {
+	return mw.cw.Write(p)
+}
+
+// next ends the current member and starts the next one, returning the offset
+// and length of the ended member in the output.
+func (mw *memberWriter) next() (offset, length int64, err error) {
+	if err := mw.cw.Close(); err != nil {
+		return 0, 0, err
+	}
+	offset, length = mw.offset, mw.out.n-mw.offset
+	return offset, length, mw.start()
+}
+
+// Close ends the current member.
+func (mw *memberWriter) Close() error {
+	return mw.cw.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// ListDir returns the entries of the archive written by PutDir with Index set
+// at bucket/rPath, reading only its index. If the archive has no index,
+// ErrPathNotFound is returned, and if it was replaced since the index was
+// written, ErrStaleIndex.
+func (cl *Client) ListDir(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	opts GetOptions,
+) (
+	[]ArchiveEntry,
+	error,
+) {
+	var index dirIndex
+	err := cl.withRetryPolicy(ctx, opts.Retry, func() (err error) {
+		index, _, err = cl.readDirIndex(ctx, bucket, rPath, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ArchiveEntry, len(index.Entries))
+	for i, e := range index.Entries {
+		entries[i] = e.ArchiveEntry
+	}
+	return entries, nil
+}
+
+// GetDirFile returns the contents of the regular file member, given as a
+// slash-separated path relative to the archived directory, of the archive
+// written by PutDir with Index set at bucket/rPath. Only the index and the
+// part of the archive holding the file are read. Missing members and
+// archives without an index give ErrPathNotFound, and archives replaced since
+// the index was written ErrStaleIndex.
+func (cl *Client) GetDirFile(
+	ctx context.Context,
+	bucket,
+	rPath,
+	member string,
+	opts GetOptions,
+) (
+	io.ReadCloser,
+	error,
+) {
+	var r io.ReadCloser
+	err := cl.withRetryPolicy(ctx, opts.Retry, func() (err error) {
+		r, err = cl.getDirFile(ctx, bucket, rPath, member, opts)
+		return err
+	})
+	return r, err
+}
+
+func (cl *Client) getDirFile(
+	ctx context.Context,
+	bucket,
+	rPath,
+	member string,
+	opts GetOptions,
+) (
+	io.ReadCloser,
+	error,
+) {
+	index, info, err := cl.readDirIndex(ctx, bucket, rPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Clean(member)
+
+	var entry *dirIndexEntry
+	for i := range index.Entries {
+		if index.Entries[i].Name == name {
+			entry = &index.Entries[i]
+			break
+		}
+	}
+	if entry == nil || !entry.Mode.IsRegular() {
+		log.Printf("No file %s in archive %s/%s", member, bucket, rPath)
+		return nil, ErrPathNotFound
+	}
+
+	obj, err := cl.getStoredRange(ctx, bucket, rPath, info, entry.Offset, entry.Length, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := decompressReader(index.Codec, obj)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+
+	closer := closerFunc(func() error {
+		decompressed.Close()
+		return obj.Close()
+	})
+
+	tr := tar.NewReader(decompressed)
+	header, err := tr.Next()
+	if err != nil {
+		closer.Close()
+		log.Printf("Failed to read tar header: %v", err)
+		return nil, err
+	}
+	if header.Name != entry.Name {
+		closer.Close()
+		log.Printf("Archive index doesn't match %s/%s", bucket, rPath)
+		return nil, ErrStaleIndex
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: newChecksumReader(tr, entry.Checksum),
+		Closer: closer,
+	}, nil
+}
+
+// readDirIndex returns the index of the archive bucket/rPath along with the
+// archive's information.
+func (cl *Client) readDirIndex(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	opts GetOptions,
+) (
+	dirIndex,
+	FileInfo,
+	error,
+) {
+	info, err := cl.Backend.Stat(ctx, bucket, rPath)
+	if err != nil {
+		return dirIndex{}, FileInfo{}, err
+	}
+
+	r, err := cl.getObject(ctx, bucket, rPath+DirIndexSuffix, GetOptions{})
+	if err != nil {
+		return dirIndex{}, FileInfo{}, err
+	}
+	defer r.Close()
+
+	// Read to the end so the checksum is verified.
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Printf("Failed to read archive index: %v", err)
+		return dirIndex{}, FileInfo{}, err
+	}
+
+	var index dirIndex
+	if err := json.Unmarshal(buf, &index); err != nil {
+		log.Printf("Failed to decode archive index: %v", err)
+		return dirIndex{}, FileInfo{}, err
+	}
+
+	if index.Checksum != info.Meta[metaChecksum] {
+		log.Printf("Archive index of %s/%s is stale", bucket, rPath)
+		return dirIndex{}, FileInfo{}, ErrStaleIndex
+	}
+
+	return index, info, nil
+}
@@ -2,6 +2,9 @@ package objstore
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	minio "github.com/minio/minio-go"
 )
@@ -9,8 +12,117 @@ import (
 var (
 	ErrPathNotFound   = errors.New("PathNotFound")
 	ErrBucketNotFound = errors.New("BucketNotFound")
+
+	// ErrDecryptFailed is returned when reading an encrypted object that has
+	// been corrupted, truncated or tampered with, or was encrypted with a
+	// different key.
+	ErrDecryptFailed = errors.New("DecryptFailed")
+
+	// ErrKeyNotFound is returned when the encryption key of an object isn't
+	// in the client's keyring.
+	ErrKeyNotFound = errors.New("KeyNotFound")
+
+	// ErrChecksumMismatch is returned at the end of reading an object whose
+	// data doesn't match the checksum recorded when it was written.
+	ErrChecksumMismatch = errors.New("ChecksumMismatch")
+
+	// ErrAmbiguousEncryption is returned by Reencrypt for objects that may
+	// be plaintext or encrypted in the legacy format.
+	ErrAmbiguousEncryption = errors.New("AmbiguousEncryption")
+
+	// ErrUnknownCodec is returned for compression codecs that aren't
+	// supported.
+	ErrUnknownCodec = errors.New("UnknownCodec")
+
+	// ErrNotSeekable is returned when reading a range of a compressed
+	// object.
+	ErrNotSeekable = errors.New("NotSeekable")
+
+	// ErrUnsafeArchive is returned when extracting an archive with entries
+	// outside the target directory, device files or hard links.
+	ErrUnsafeArchive = errors.New("UnsafeArchive")
+
+	// ErrArchiveTooLarge is returned when extracting an archive that exceeds
+	// the size or file count limit.
+	ErrArchiveTooLarge = errors.New("ArchiveTooLarge")
+
+	// ErrStaleIndex is returned when an archive's index doesn't match the
+	// archive, e.g. because the archive was replaced without an index.
+	ErrStaleIndex = errors.New("StaleIndex")
+
+	// ErrInvalidEncKey is returned when an encryption key isn't 16, 24 or 32
+	// bytes long, or a keyring's primary key is missing.
+	ErrInvalidEncKey = errors.New("InvalidEncKey")
 )
 
+// MultiError is returned by bulk operations, such as Delete, that failed for
+// some objects. It holds the error for each object that failed, keyed by path.
+//
+// errors.Is and errors.As match if they match the error of any object, so
+// errors.Is(err, ErrBucketNotFound) is true if any object failed because its
+// bucket doesn't exist.
+type MultiError struct {
+	Errs map[string]error
+}
+
+// multiErrorMaxListed is the number of failures listed by MultiError.Error.
+const multiErrorMaxListed = 10
+
+// newMultiError returns a MultiError for errs, or nil if errs is empty.
+func newMultiError(errs map[string]error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &MultiError{Errs: errs}
+}
+
+// Keys returns the paths of the objects that failed in sorted order, e.g. to
+// retry them.
+func (e *MultiError) Keys() []string {
+	keys := make([]string, 0, len(e.Errs))
+	for key := range e.Errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e *MultiError) Error() string {
+	keys := e.Keys()
+	msgs := []string{}
+	for i, key := range keys {
+		if i == multiErrorMaxListed {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(keys)-i))
+			break
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %v", key, e.Errs[key]))
+	}
+	return fmt.Sprintf("%d objects failed: %s", len(keys), strings.Join(msgs, "; "))
+}
+
+// Is reports whether the error of any object matches target.
+func (e *MultiError) Is(target error) bool {
+	for _, key := range e.Keys() {
+		if errors.Is(e.Errs[key], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first object error in path order that matches target, and if
+// so, sets target to it.
+func (e *MultiError) As(target interface{}) bool {
+	for _, key := range e.Keys() {
+		if errors.As(e.Errs[key], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+
 func convertError(err error) error {
 	switch minio.ToErrorResponse(err).Code {
 
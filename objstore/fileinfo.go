@@ -8,4 +8,31 @@ type FileInfo struct {
 	Name    string    // Full path to the object.
 	ModTime time.Time // Modification time.
 	Size    int64     // Size in storage.
+
+	// IsDir is set for common prefixes in non-recursive listings, which stand
+	// for all objects below them like a directory. Their names end in the
+	// delimiter, and they have no other fields set.
+	IsDir bool
+
+	// The fields below are only set by Stat and ListMeta, and only if they
+	// were recorded when the object was written.
+
+	// Checksum is the hex encoded SHA-256 of the data as returned by Get.
+	Checksum string
+
+	// Encryption is EncryptionNone or EncryptionAESGCM. It's empty for
+	// objects written before it was recorded.
+	Encryption string
+
+	// FileSize and FileModTime are the size and modification time of the
+	// local file written with PutObjectFile.
+	FileSize    int64
+	FileModTime time.Time
+
+	// Metadata given when writing the object.
+	ObjectMeta
+
+	// Meta is the metadata stored with the object by the Backend. It's also
+	// set by Backend.Get.
+	Meta map[string]string
 }
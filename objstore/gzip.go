@@ -3,22 +3,32 @@ package objstore
 import (
 	"compress/gzip"
 	"io"
+	"log"
 )
 
-// Wrap the reader in a new reading returning compressed data.
-func gzipReader(rRaw io.Reader) io.Reader {
-	r, w := io.Pipe()
-	go func() {
-		defer w.Close()
+// CodecGzip is the name of the gzip codec, see PutOptions.Codec. Its levels
+// are those of compress/gzip, except that 0 selects gzip.BestSpeed.
+const CodecGzip = "gzip"
 
-		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-		defer gz.Close()
-		if err != nil {
-			w.CloseWithError(err)
-		}
+type gzipCodec struct{}
 
-		// TODO: Can this return an error?
-		io.Copy(gz, rRaw)
-	}()
-	return r
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.BestSpeed
+	}
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		log.Printf("Failed to create gzip writer: %v", err)
+		return nil, err
+	}
+	return gz, nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		log.Printf("Failed to create gzip reader: %v", err)
+		return nil, err
+	}
+	return gr, nil
 }
@@ -0,0 +1,117 @@
+package objstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+)
+
+// Keyring holds the encryption keys of a Client, identified by key ID.
+//
+// New objects are encrypted with the primary key, and the primary key's ID is
+// stored in each object's metadata. When reading, the key is selected by the
+// ID in the metadata, so old keys can be kept in the keyring for decryption until
+// all objects using them have been re-encrypted.
+type Keyring struct {
+	Primary string            // ID of the key used to encrypt new objects.
+	Keys    map[string][]byte // Keys by ID. IDs must be printable ASCII.
+
+	// Legacy is the ID of the key used to decrypt objects written in the
+	// legacy AES-CTR format, which has no key ID. Default: Primary.
+	Legacy string
+}
+
+// LoadKeyring reads a keyring from the JSON file at path. Keys are base64
+// encoded:
+//
+//	{
+//	  "Primary": "2020-05",
+//	  "Keys": {
+//	    "2020-01": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+//	    "2020-05": "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXowMTIzNDU="
+//	  }
+//	}
+func LoadKeyring(path string) (*Keyring, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read keyring %s: %v", path, err)
+		return nil, err
+	}
+
+	kr := &Keyring{}
+	if err := json.Unmarshal(buf, kr); err != nil {
+		log.Printf("Failed to parse keyring %s: %v", path, err)
+		return nil, err
+	}
+
+	if err := kr.validate(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// validate checks that the primary key exists and all keys are valid.
+func (kr *Keyring) validate() error {
+	if _, _, err := kr.primaryKey(); err != nil {
+		return ErrInvalidEncKey
+	}
+	for id, key := range kr.Keys {
+		if err := validateEncKey(key); err != nil {
+			log.Printf("Invalid encryption key %q", id)
+			return err
+		}
+	}
+	return nil
+}
+
+func validateEncKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	log.Printf("Encryption key must be 16, 24 or 32 bytes long, not %d", len(key))
+	return ErrInvalidEncKey
+}
+
+// primaryKey returns the ID and key used to encrypt new objects.
+func (kr *Keyring) primaryKey() (string, []byte, error) {
+	// The ID is stored as metadata, i.e. in an HTTP header.
+	for _, c := range kr.Primary {
+		if c < ' ' || c > '~' {
+			log.Printf("Key ID isn't printable ASCII: %q", kr.Primary)
+			return "", nil, ErrKeyNotFound
+		}
+	}
+	key, err := kr.key(kr.Primary)
+	return kr.Primary, key, err
+}
+
+// legacyKey returns the key for objects in the legacy format.
+func (kr *Keyring) legacyKey() ([]byte, error) {
+	if kr.Legacy != "" {
+		return kr.key(kr.Legacy)
+	}
+	return kr.key(kr.Primary)
+}
+
+func (kr *Keyring) key(id string) ([]byte, error) {
+	key, ok := kr.Keys[id]
+	if !ok {
+		log.Printf("Encryption key not found: %q", id)
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// keyring returns the client's keyring. If Keys isn't set, a keyring holding
+// only EncKey, with the empty ID, is returned.
+func (cl *Client) keyring() *Keyring {
+	if cl.Keys != nil {
+		return cl.Keys
+	}
+	return &Keyring{
+		Keys: map[string][]byte{"": cl.EncKey},
+	}
+}
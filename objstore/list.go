@@ -0,0 +1,142 @@
+package objstore
+
+import (
+	"context"
+)
+
+// DefaultListPageSize is the default number of entries a Lister fetches per
+// request.
+const DefaultListPageSize = 1000
+
+// ListOptions configures NewLister.
+type ListOptions struct {
+	// Recursive lists all objects below the prefix. Otherwise, objects below
+	// the next Delimiter after the prefix are listed as a single entry with
+	// IsDir set, whose name ends in the delimiter.
+	Recursive bool
+	Delimiter string // Default: "/".
+
+	// StartAfter resumes a listing after the given name, e.g. the Token of
+	// an earlier Lister.
+	StartAfter string
+
+	MaxKeys  int // Maximum number of entries listed. 0: no limit.
+	PageSize int // Entries fetched per request. Default: DefaultListPageSize.
+}
+
+// Lister iterates over the objects in a bucket with a given prefix in name
+// order, fetching them a page at a time:
+//
+//	it := cl.NewLister(ctx, bucket, prefix, ListOptions{})
+//	for it.Next() {
+//		info := it.Info()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Iteration can be stopped at any point, and resumed later by passing Token as
+// ListOptions.StartAfter.
+type Lister struct {
+	cl     *Client
+	ctx    context.Context
+	bucket string
+	prefix string
+	opts   ListOptions
+
+	page  []FileInfo
+	info  FileInfo
+	count int  // Entries returned so far.
+	done  bool // No more pages.
+	err   error
+}
+
+// ----------------------------------------------------------------------------
+
+// NewLister returns a Lister for the objects in bucket with the given prefix.
+// Nothing is fetched before the first call to Next.
+func (cl *Client) NewLister(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	opts ListOptions,
+) *Lister {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultListPageSize
+	}
+	return &Lister{
+		cl:     cl,
+		ctx:    ctx,
+		bucket: bucket,
+		prefix: prefix,
+		opts:   opts,
+	}
+}
+
+// Next advances to the next entry, which is then returned by Info. It returns
+// false once the listing is complete, MaxKeys entries were returned, or an
+// error occurred.
+func (it *Lister) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.opts.MaxKeys > 0 && it.count >= it.opts.MaxKeys {
+		return false
+	}
+
+	if len(it.page) == 0 {
+		if it.done {
+			return false
+		}
+		if it.err = it.fetch(); it.err != nil || len(it.page) == 0 {
+			return false
+		}
+	}
+
+	it.info = it.page[0]
+	it.page = it.page[1:]
+	it.count++
+	return true
+}
+
+// Info returns the current entry.
+func (it *Lister) Info() FileInfo {
+	return it.info
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *Lister) Err() error {
+	return it.err
+}
+
+// Token returns the continuation token for the entries after the current
+// one, to be passed as ListOptions.StartAfter. Before the first call to Next,
+// it's the StartAfter the Lister was created with.
+func (it *Lister) Token() string {
+	if it.count == 0 {
+		return it.opts.StartAfter
+	}
+	return it.info.Name
+}
+
+func (it *Lister) fetch() error {
+	maxKeys := it.opts.PageSize
+	if it.opts.MaxKeys > 0 && it.opts.MaxKeys-it.count < maxKeys {
+		maxKeys = it.opts.MaxKeys - it.count
+	}
+
+	beOpts := BackendListOptions{
+		Recursive:  it.opts.Recursive,
+		Delimiter:  it.opts.Delimiter,
+		StartAfter: it.Token(),
+		MaxKeys:    maxKeys,
+	}
+
+	return it.cl.withRetry(it.ctx, func() (err error) {
+		var truncated bool
+		it.page, truncated, err = it.cl.Backend.List(it.ctx, it.bucket, it.prefix, beOpts)
+		it.done = !truncated
+		return err
+	})
+}
@@ -0,0 +1,141 @@
+package objstore
+
+import (
+	"context"
+	"testing"
+)
+
+// listBackend counts the calls to List.
+type listBackend struct {
+	Backend
+	Calls int
+}
+
+func (be *listBackend) List(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	opts BackendListOptions,
+) (
+	[]FileInfo,
+	bool,
+	error,
+) {
+	be.Calls++
+	return be.Backend.List(ctx, bucket, prefix, opts)
+}
+
+func TestClientLister(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	names := []string{"l/a/b/c", "l/a/b/d", "l/a/e", "l/a-f", "l/g", "l/h"}
+	for _, rPath := range names {
+		if err := cl.PutBytes([]byte(rPath), testBucket, rPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type TestCase struct {
+		Opts  ListOptions
+		Names []string
+	}
+
+	cases := []TestCase{
+		{
+			Opts:  ListOptions{Recursive: true, PageSize: 2},
+			Names: []string{"l/a-f", "l/a/b/c", "l/a/b/d", "l/a/e", "l/g", "l/h"},
+		}, {
+			Opts:  ListOptions{PageSize: 1},
+			Names: []string{"l/a-f", "l/a/", "l/g", "l/h"},
+		}, {
+			Opts:  ListOptions{Recursive: true, MaxKeys: 3, PageSize: 2},
+			Names: []string{"l/a-f", "l/a/b/c", "l/a/b/d"},
+		}, {
+			Opts:  ListOptions{Recursive: true, StartAfter: "l/a/b/d", PageSize: 2},
+			Names: []string{"l/a/e", "l/g", "l/h"},
+		}, {
+			Opts:  ListOptions{StartAfter: "l/a/"},
+			Names: []string{"l/g", "l/h"},
+		}, {
+			Opts:  ListOptions{Delimiter: "-", PageSize: 1},
+			Names: []string{"l/a-", "l/a/b/c", "l/a/b/d", "l/a/e", "l/g", "l/h"},
+		}, {
+			Opts:  ListOptions{Delimiter: "b", StartAfter: "l/a/b", PageSize: 2},
+			Names: []string{"l/a/e", "l/g", "l/h"},
+		},
+	}
+
+	for _, tc := range cases {
+		it := cl.NewLister(ctx, testBucket, "l/", tc.Opts)
+		l := []string{}
+		for it.Next() {
+			l = append(l, it.Info().Name)
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(l) != len(tc.Names) {
+			t.Fatalf("%+v: %v != %v", tc.Opts, l, tc.Names)
+		}
+		for i := range l {
+			if l[i] != tc.Names[i] {
+				t.Fatalf("%+v: %v != %v", tc.Opts, l, tc.Names)
+			}
+		}
+	}
+
+	// Common prefixes are flagged.
+	l := []FileInfo{}
+	it := cl.NewLister(ctx, testBucket, "l/a", ListOptions{Delimiter: "/"})
+	for it.Next() {
+		l = append(l, it.Info())
+	}
+	if it.Err() != nil || len(l) != 2 {
+		t.Fatal(l, it.Err())
+	}
+	if l[0].Name != "l/a-f" || l[0].IsDir || l[1].Name != "l/a/" || !l[1].IsDir {
+		t.Fatal(l)
+	}
+
+	// Stop early and resume from the token.
+	it = cl.NewLister(ctx, testBucket, "l/a", ListOptions{Recursive: true, PageSize: 2})
+	for i := 0; i < 2; i++ {
+		if !it.Next() {
+			t.Fatal(it.Err())
+		}
+	}
+	if it.Token() != "l/a/b/c" {
+		t.Fatal(it.Token())
+	}
+
+	it = cl.NewLister(ctx, testBucket, "l/a", ListOptions{
+		Recursive:  true,
+		StartAfter: it.Token(),
+	})
+	resumed := []string{}
+	for it.Next() {
+		resumed = append(resumed, it.Info().Name)
+	}
+	if it.Err() != nil || len(resumed) != 2 || resumed[0] != "l/a/b/d" || resumed[1] != "l/a/e" {
+		t.Fatal(resumed, it.Err())
+	}
+
+	// A full last page doesn't need another request.
+	be := &listBackend{Backend: cl.Backend}
+	cl.Backend = be
+	it = cl.NewLister(ctx, testBucket, "l/", ListOptions{Recursive: true, PageSize: 3})
+	for it.Next() {
+	}
+	if it.Err() != nil || be.Calls != 2 {
+		t.Fatal(be.Calls, it.Err())
+	}
+
+	// Errors end the iteration.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	it = cl.NewLister(ctx, testBucket, "", ListOptions{})
+	if it.Next() || it.Err() != context.Canceled {
+		t.Fatal(it.Err())
+	}
+}
@@ -0,0 +1,669 @@
+package objstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+var errInvalidPath = errors.New("InvalidPath")
+
+// localBackend stores objects as files on local disk. Each bucket is a
+// directory below root, and object keys map onto paths within it.
+//
+// Unlike S3, a key can't be both an object and a prefix of another object,
+// e.g. "a/b" and "a/b/c" can't exist at the same time.
+type localBackend struct {
+	root string
+}
+
+// NewLocalBackend returns a Backend storing objects below the directory root.
+// Buckets are the directories directly below root and must be created by the
+// caller.
+func NewLocalBackend(root string) Backend {
+	return &localBackend{root: root}
+}
+
+// ----------------------------------------------------------------------------
+
+// bucketPath returns the local path for the given bucket, checking that it
+// exists. Bucket names can't contain path separators or start with a dot, so
+// they always map to a directory directly below root, and never to one of the
+// directories used internally.
+func (be *localBackend) bucketPath(bucket string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket[0] == '.' {
+		return "", ErrBucketNotFound
+	}
+
+	path := filepath.Join(be.root, bucket)
+	if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+		return "", ErrBucketNotFound
+	}
+	return path, nil
+}
+
+// objectPath returns the local path for the given object, checking that the
+// bucket exists.
+func (be *localBackend) objectPath(bucket, rPath string) (string, error) {
+	if rPath == "" || strings.HasSuffix(rPath, "/") {
+		return "", errInvalidPath
+	}
+	for _, part := range strings.Split(rPath, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", errInvalidPath
+		}
+	}
+
+	bucketPath, err := be.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(bucketPath, filepath.FromSlash(rPath)), nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *localBackend) Put(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	r io.Reader,
+	opts BackendPutOptions,
+) error {
+	path, err := be.objectPath(bucket, rPath)
+	if err != nil {
+		return err
+	}
+
+	// The metadata is written after the data, so the trailer is included.
+	meta := func() (map[string]string, error) {
+		if opts.Trailer != nil {
+			return opts.trailerMeta()
+		}
+		return opts.Meta, nil
+	}
+
+	if err := be.writeFile(path, ctxReader{ctx, r}, meta); err != nil {
+		log.Printf("Failed to put object %s/%s: %v", bucket, rPath, err)
+		return err
+	}
+
+	return nil
+}
+
+// writeFile writes the data read from r, followed by the metadata returned by
+// meta, to path, creating parent directories as needed.
+func (be *localBackend) writeFile(
+	path string,
+	r io.Reader,
+	meta func() (map[string]string, error),
+) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial file.
+	// Bucket names can't start with a dot, so the directory can't collide
+	// with a bucket.
+	tmpDir := filepath.Join(be.root, ".tmp")
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(tmpDir, "put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := writeObject(f, r, meta); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// ----------------------------------------------------------------------------
+
+// An object is stored in a single file holding the data, followed by the
+// metadata as JSON and the length of the JSON as a 4 byte big-endian integer.
+// Data and metadata are replaced by a single rename, so the data of one write
+// is never paired with the metadata, e.g. the data key, of another.
+
+const localMetaLenSize = 4
+
+var errInvalidObjectFile = errors.New("InvalidObjectFile")
+
+// writeObject writes the data read from r, followed by the metadata returned
+// by meta, to w.
+func writeObject(
+	w io.Writer,
+	r io.Reader,
+	meta func() (map[string]string, error),
+) error {
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	m, err := meta()
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = map[string]string{}
+	}
+
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	var size [localMetaLenSize]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(buf)))
+	if _, err := w.Write(append(buf, size[:]...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// readObjectMeta returns the size of the data and the metadata of the object
+// stored in r, a file of the given size.
+func readObjectMeta(r io.ReaderAt, size int64) (int64, map[string]string, error) {
+	if size < localMetaLenSize {
+		return 0, nil, errInvalidObjectFile
+	}
+
+	var metaSize [localMetaLenSize]byte
+	if _, err := r.ReadAt(metaSize[:], size-localMetaLenSize); err != nil {
+		return 0, nil, err
+	}
+
+	dataSize := size - localMetaLenSize - int64(binary.BigEndian.Uint32(metaSize[:]))
+	if dataSize < 0 {
+		return 0, nil, errInvalidObjectFile
+	}
+
+	buf := make([]byte, size-localMetaLenSize-dataSize)
+	if _, err := r.ReadAt(buf, dataSize); err != nil {
+		return 0, nil, err
+	}
+
+	meta := map[string]string{}
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return 0, nil, errInvalidObjectFile
+	}
+	if len(meta) == 0 {
+		meta = nil
+	}
+
+	return dataSize, meta, nil
+}
+
+// openObject opens the object file at path, returning its FileInfo without
+// the name.
+func openObject(path string) (*os.File, FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, FileInfo{}, convertLocalError(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, FileInfo{}, ErrPathNotFound
+	}
+
+	size, meta, err := readObjectMeta(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, FileInfo{}, err
+	}
+
+	return f, FileInfo{
+		ModTime: fi.ModTime().UTC(),
+		Size:    size,
+		Meta:    meta,
+	}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *localBackend) SetMeta(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	meta map[string]string,
+) error {
+	path, err := be.objectPath(bucket, rPath)
+	if err != nil {
+		return err
+	}
+
+	// The data is copied along with the new metadata, like S3 copies an
+	// object onto itself.
+	f, info, err := openObject(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	src := ctxReader{ctx, io.NewSectionReader(f, 0, info.Size)}
+	err = be.writeFile(path, src, func() (map[string]string, error) {
+		return meta, nil
+	})
+	if err != nil {
+		log.Printf("Failed to set metadata of %s/%s: %v", bucket, rPath, err)
+		return err
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *localBackend) Get(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	io.ReadCloser,
+	FileInfo,
+	error,
+) {
+	path, err := be.objectPath(bucket, rPath)
+	if err != nil {
+		return nil, FileInfo{}, convertLocalError(err)
+	}
+
+	f, info, err := openObject(path)
+	if err != nil {
+		log.Printf("Failed to get object %s/%s: %v", bucket, rPath, err)
+		return nil, FileInfo{}, err
+	}
+	info.Name = rPath
+
+	obj := struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: ctxReader{ctx, io.NewSectionReader(f, 0, info.Size)},
+		Closer: f,
+	}
+
+	return obj, info, nil
+}
+
+func (be *localBackend) GetRange(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	path, err := be.objectPath(bucket, rPath)
+	if err != nil {
+		return nil, convertLocalError(err)
+	}
+
+	f, info, err := openObject(path)
+	if err != nil {
+		log.Printf("Failed to get object %s/%s: %v", bucket, rPath, err)
+		return nil, err
+	}
+
+	if offset < 0 {
+		f.Close()
+		log.Printf("Failed to seek in object %s/%s: offset %d", bucket, rPath, offset)
+		return nil, errInvalidOffset
+	}
+
+	// Like a file, reading past the end returns no data.
+	if offset > info.Size {
+		offset = info.Size
+	}
+	if length < 0 || length > info.Size-offset {
+		length = info.Size - offset
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: ctxReader{ctx, io.NewSectionReader(f, offset, length)},
+		Closer: f,
+	}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *localBackend) Stat(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	FileInfo,
+	error,
+) {
+	if err := ctx.Err(); err != nil {
+		return FileInfo{}, err
+	}
+
+	path, err := be.objectPath(bucket, rPath)
+	if err != nil {
+		return FileInfo{}, convertLocalError(err)
+	}
+
+	f, info, err := openObject(path)
+	if err != nil {
+		log.Printf("Failed to stat object %s/%s: %v", bucket, rPath, err)
+		return FileInfo{}, err
+	}
+	f.Close()
+
+	info.Name = rPath
+	return info, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *localBackend) List(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	opts BackendListOptions,
+) (
+	[]FileInfo,
+	bool,
+	error,
+) {
+	bucketPath, err := be.bucketPath(bucket)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// Only the directory containing the prefix needs to be searched.
+	dir := ""
+	if idx := strings.LastIndex(prefix, "/"); idx != -1 {
+		dir = prefix[:idx+1]
+	}
+	dirPath := filepath.Join(bucketPath, filepath.FromSlash(dir))
+
+	delimiter := opts.Delimiter
+	if delimiter == "" {
+		delimiter = "/"
+	}
+
+	l := []FileInfo{}
+
+	// Directories are common prefixes for the default delimiter. For others,
+	// all objects are walked and grouped as they're listed.
+	group := !opts.Recursive && delimiter != "/"
+	recurse := opts.Recursive || group
+
+	err = walkObjects(ctx, dirPath, dir, prefix, opts.StartAfter, recurse, func(info FileInfo) bool {
+		if group {
+			if idx := strings.Index(info.Name[len(prefix):], delimiter); idx != -1 {
+				// Common prefixes not sorting after StartAfter were
+				// partly listed already.
+				name := info.Name[:len(prefix)+idx+len(delimiter)]
+				if name <= opts.StartAfter || len(l) > 0 && l[len(l)-1].Name == name {
+					return true
+				}
+				info = FileInfo{Name: name, IsDir: true}
+			}
+		}
+		l = append(l, info)
+
+		// One more entry than MaxKeys tells if the listing is complete.
+		return opts.MaxKeys <= 0 || len(l) <= opts.MaxKeys
+	})
+	if err != nil {
+		log.Printf("Error listing objects: %v", err)
+		return nil, false, err
+	}
+
+	if opts.MaxKeys > 0 && len(l) > opts.MaxKeys {
+		return l[:opts.MaxKeys], true, nil
+	}
+	return l, false, nil
+}
+
+// walkObjects calls fn in name order, like S3 sorts keys, for the objects
+// with the given prefix sorting after startAfter in the directory dirPath,
+// which holds the objects whose names start with dir. If recurse is set,
+// objects in subdirectories are included. Otherwise subdirectories are passed
+// as entries with IsDir set. Walking stops once fn returns false, and skips
+// directories that can't hold objects to be passed.
+func walkObjects(
+	ctx context.Context,
+	dirPath,
+	dir,
+	prefix,
+	startAfter string,
+	recurse bool,
+	fn func(info FileInfo) bool,
+) error {
+	_, err := walkDir(ctx, dirPath, dir, prefix, startAfter, recurse, fn)
+	return err
+}
+
+// walkDir is walkObjects, returning false if fn stopped the walk.
+func walkDir(
+	ctx context.Context,
+	dirPath,
+	dir,
+	prefix,
+	startAfter string,
+	recurse bool,
+	fn func(info FileInfo) bool,
+) (
+	bool,
+	error,
+) {
+	entries, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		// Directories may be removed along with their last object, and a
+		// prefix may name an object rather than a directory.
+		if convertLocalError(err) == ErrPathNotFound {
+			return true, nil
+		}
+		return false, err
+	}
+
+	// A directory's objects sort as names ending in a slash, so "a-b" comes
+	// before "a/b".
+	names := make([]string, len(entries))
+	for i, fi := range entries {
+		names[i] = dir + fi.Name()
+		if fi.IsDir() {
+			names[i] += "/"
+		}
+	}
+	sort.Sort(fileInfosByName{entries, names})
+
+	for i, fi := range entries {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		name := names[i]
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		switch {
+		case !fi.IsDir():
+			if name <= startAfter {
+				continue
+			}
+			// Objects may be removed while listing.
+			f, info, err := openObject(filepath.Join(dirPath, fi.Name()))
+			if err == ErrPathNotFound {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			f.Close()
+			if !fn(FileInfo{Name: name, ModTime: info.ModTime, Size: info.Size}) {
+				return false, nil
+			}
+
+		case !recurse:
+			if name <= startAfter {
+				continue
+			}
+			if !fn(FileInfo{Name: name, IsDir: true}) {
+				return false, nil
+			}
+
+		// Skip directories only holding objects up to startAfter.
+		case name > startAfter || strings.HasPrefix(startAfter, name):
+			subPath := filepath.Join(dirPath, fi.Name())
+			if ok, err := walkDir(ctx, subPath, name, prefix, startAfter, recurse, fn); !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// fileInfosByName sorts entries by the names in the same order.
+type fileInfosByName struct {
+	entries []os.FileInfo
+	names   []string
+}
+
+func (s fileInfosByName) Len() int           { return len(s.entries) }
+func (s fileInfosByName) Less(i, j int) bool { return s.names[i] < s.names[j] }
+
+func (s fileInfosByName) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.names[i], s.names[j] = s.names[j], s.names[i]
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *localBackend) Delete(
+	ctx context.Context,
+	bucket string,
+	rPaths ...string,
+) error {
+	if _, err := be.bucketPath(bucket); err != nil {
+		return err
+	}
+
+	errs := map[string]error{}
+	for _, rPath := range rPaths {
+		if err := ctx.Err(); err != nil {
+			errs[rPath] = err
+			continue
+		}
+		if err := be.delete(bucket, rPath); err != nil {
+			log.Printf("Failed to delete object %s: %v", rPath, err)
+			errs[rPath] = err
+		}
+	}
+	return newMultiError(errs)
+}
+
+func (be *localBackend) delete(bucket, rPath string) error {
+	path, err := be.objectPath(bucket, rPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && convertLocalError(err) != ErrPathNotFound {
+		return err
+	}
+
+	// Remove empty parent directories, as S3 has no notion of an empty
+	// prefix.
+	removeEmptyDirs(filepath.Dir(path), filepath.Join(be.root, bucket))
+
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, but not including, stop
+// as long as they're empty.
+func removeEmptyDirs(dir, stop string) {
+	for ; dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *localBackend) Copy(
+	ctx context.Context,
+	srcBucket,
+	srcPath,
+	dstBucket,
+	dstPath string,
+	meta map[string]string,
+) error {
+	src, info, err := be.Get(ctx, srcBucket, srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if meta == nil {
+		meta = info.Meta
+	}
+
+	if err := be.Put(ctx, dstBucket, dstPath, src, BackendPutOptions{Meta: meta}); err != nil {
+		log.Printf("Failed to copy %s/%s -> %s/%s: %v",
+			srcBucket, srcPath, dstBucket, dstPath, err)
+		return err
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+func convertLocalError(err error) error {
+	// ENOTDIR means a parent of the path is an object, not a prefix.
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return ErrPathNotFound
+	}
+	return err
+}
+
+// ctxReader fails reads once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
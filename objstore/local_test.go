@@ -0,0 +1,253 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newLocalBackendForTesting(t *testing.T) Backend {
+	root := filepath.Join("files", "out", "local")
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, testBucket), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return NewLocalBackend(root)
+}
+
+func TestLocalBackendList(t *testing.T) {
+	be := newLocalBackendForTesting(t)
+	ctx := context.Background()
+
+	for _, rPath := range []string{"a/b/c", "a/b/d", "a/e", "a-f", "g"} {
+		if err := be.Put(ctx, testBucket, rPath, bytes.NewBufferString(rPath), BackendPutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type TestCase struct {
+		Prefix     string
+		Recursive  bool
+		Delimiter  string
+		StartAfter string
+		MaxKeys    int
+		Names      []string
+		Truncated  bool
+	}
+
+	cases := []TestCase{
+		{
+			Prefix:    "",
+			Recursive: true,
+			Names:     []string{"a-f", "a/b/c", "a/b/d", "a/e", "g"},
+		}, {
+			Prefix:    "",
+			Recursive: false,
+			Names:     []string{"a-f", "a/", "g"},
+		}, {
+			Prefix:    "a/",
+			Recursive: false,
+			Names:     []string{"a/b/", "a/e"},
+		}, {
+			Prefix:    "a/b",
+			Recursive: true,
+			Names:     []string{"a/b/c", "a/b/d"},
+		}, {
+			Prefix:    "x/",
+			Recursive: true,
+			Names:     []string{},
+		}, {
+			Prefix:    "g/",
+			Recursive: true,
+			Names:     []string{},
+		}, {
+			Prefix:    "a/e/",
+			Recursive: false,
+			Names:     []string{},
+		}, {
+			Prefix:     "",
+			Recursive:  true,
+			StartAfter: "a/b/c",
+			MaxKeys:    2,
+			Names:      []string{"a/b/d", "a/e"},
+			Truncated:  true,
+		}, {
+			Prefix:     "",
+			Recursive:  true,
+			StartAfter: "a/e",
+			MaxKeys:    1,
+			Names:      []string{"g"},
+		}, {
+			Prefix:    "",
+			Recursive: false,
+			Delimiter: "/",
+			MaxKeys:   2,
+			Names:     []string{"a-f", "a/"},
+			Truncated: true,
+		}, {
+			Prefix:     "",
+			Recursive:  false,
+			StartAfter: "a-f",
+			Names:      []string{"a/", "g"},
+		}, {
+			Prefix:     "",
+			Recursive:  false,
+			StartAfter: "a/",
+			Names:      []string{"g"},
+		}, {
+			Prefix:    "a",
+			Recursive: false,
+			Delimiter: "-",
+			Names:     []string{"a-", "a/b/c", "a/b/d", "a/e"},
+		}, {
+			Prefix:    "a/",
+			Recursive: false,
+			Delimiter: "b",
+			Names:     []string{"a/b", "a/e"},
+		}, {
+			Prefix:     "",
+			Recursive:  false,
+			Delimiter:  "/b/",
+			StartAfter: "a/b/c",
+			Names:      []string{"a/e", "g"},
+		},
+	}
+
+	for _, tc := range cases {
+		l, truncated, err := be.List(ctx, testBucket, tc.Prefix, BackendListOptions{
+			Recursive:  tc.Recursive,
+			Delimiter:  tc.Delimiter,
+			StartAfter: tc.StartAfter,
+			MaxKeys:    tc.MaxKeys,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l) != len(tc.Names) || truncated != tc.Truncated {
+			t.Fatalf("%v: %v != %v", tc.Prefix, l, tc.Names)
+		}
+		for i := range l {
+			if l[i].Name != tc.Names[i] {
+				t.Fatalf("%v: %v != %v", tc.Prefix, l, tc.Names)
+			}
+		}
+	}
+}
+
+func TestLocalBackendDelete(t *testing.T) {
+	be := newLocalBackendForTesting(t)
+	ctx := context.Background()
+
+	if err := be.Put(ctx, testBucket, "a/b/c", bytes.NewBufferString("x"), BackendPutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := be.Delete(ctx, testBucket, "a/b/c", "not/a/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := be.Stat(ctx, testBucket, "a/b/c"); err != ErrPathNotFound {
+		t.Fatal(err)
+	}
+
+	// Empty prefixes are removed along with the object.
+	l, _, err := be.List(ctx, testBucket, "", BackendListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 0 {
+		t.Fatal(l)
+	}
+}
+
+func TestLocalBackendBucketNotFound(t *testing.T) {
+	be := newLocalBackendForTesting(t)
+	ctx := context.Background()
+
+	if _, err := be.Stat(ctx, "not-a-bucket", "a"); err != ErrBucketNotFound {
+		t.Fatal(err)
+	}
+	if _, _, err := be.List(ctx, "not-a-bucket", "", BackendListOptions{Recursive: true}); err != ErrBucketNotFound {
+		t.Fatal(err)
+	}
+
+	// Bucket names can't reach outside root or the internal directories,
+	// even if they exist.
+	if err := be.Put(ctx, testBucket, "a", bytes.NewBufferString("x"), BackendPutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range []string{"", ".", "..", "../local", testBucket + "/a", ".tmp"} {
+		err := be.Put(ctx, bucket, "b", bytes.NewBufferString("x"), BackendPutOptions{})
+		if err != ErrBucketNotFound {
+			t.Fatal(bucket, err)
+		}
+		if _, _, err := be.List(ctx, bucket, "", BackendListOptions{Recursive: true}); err != ErrBucketNotFound {
+			t.Fatal(bucket, err)
+		}
+		if err := be.Delete(ctx, bucket, "a"); err != ErrBucketNotFound {
+			t.Fatal(bucket, err)
+		}
+	}
+}
+
+func TestLocalBackendMeta(t *testing.T) {
+	be := newLocalBackendForTesting(t)
+	ctx := context.Background()
+
+	meta := map[string]string{"Sha256": "x"}
+	if err := be.Put(ctx, testBucket, "a/b", bytes.NewBufferString("x"), BackendPutOptions{Meta: meta}); err != nil {
+		t.Fatal(err)
+	}
+	if err := be.Copy(ctx, testBucket, "a/b", testBucket, "c", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := be.Stat(ctx, testBucket, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Meta["Sha256"] != "x" {
+		t.Fatal(info.Meta)
+	}
+
+	if err := be.SetMeta(ctx, testBucket, "d", meta); err != ErrPathNotFound {
+		t.Fatal(err)
+	}
+
+	// Replacing an object replaces its metadata.
+	if err := be.Put(ctx, testBucket, "a/b", bytes.NewBufferString("y"), BackendPutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	r, info, err := be.Get(ctx, testBucket, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if info.Meta != nil {
+		t.Fatal(info.Meta)
+	}
+
+	// A failed write leaves both the data and metadata in place.
+	failed := errors.New("failed")
+	err = be.Put(ctx, testBucket, "c", bytes.NewBufferString("z"), BackendPutOptions{
+		Trailer: func() (map[string]string, error) { return nil, failed },
+	})
+	if err != failed {
+		t.Fatal(err)
+	}
+	r, info, err = be.Get(ctx, testBucket, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil || string(buf) != "x" || info.Size != 1 || info.Meta["Sha256"] != "x" {
+		t.Fatal(string(buf), info, err)
+	}
+}
@@ -0,0 +1,129 @@
+package objstore
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectMeta is the metadata that can be given when writing an object. It's
+// returned by Stat and ListMeta as part of FileInfo.
+type ObjectMeta struct {
+	// ContentType is the MIME type of the data, e.g. "application/json".
+	ContentType string
+
+	// ContentEncoding is the encoding applied to the data, e.g. "gzip". The
+	// codec given in PutOptions is appended to it.
+	ContentEncoding string
+
+	// UserMeta holds user defined metadata, such as the producing job or a
+	// schema version. Keys are returned in canonical form, see
+	// http.CanonicalHeaderKey. Keys and values should be ASCII, as they're
+	// sent as HTTP headers.
+	UserMeta map[string]string
+}
+
+// Values of FileInfo.Encryption.
+const (
+	EncryptionNone   = "none"    // Written by the NC variants of Put.
+	EncryptionAESGCM = "aes-gcm" // Encrypted with EncKey or Keys.
+
+	// EncryptionLegacy is the legacy AES-CTR format. It's never recorded,
+	// see ReencryptOptions.Classify.
+	EncryptionLegacy = "aes-ctr"
+)
+
+// Keys of the metadata stored with each object. Content type and encoding
+// aren't stored as the HTTP headers of the same name, as they describe the
+// data before encryption.
+const (
+	metaChecksum        = "Sha256"
+	metaContentType     = "Type"
+	metaContentEncoding = "Encoding"
+	metaEncryption      = "Encryption"
+	metaCodec           = "Codec" // Only set if this package compressed the data.
+	metaFileSize        = "File-Size"
+	metaFileModTime     = "File-Mtime"
+	metaEncKeyID        = "Enc-Key-Id"   // Of the key wrapping the data key.
+	metaEncDataKey      = "Enc-Data-Key" // Wrapped data key, base64 encoded.
+	metaUserPrefix      = "User-"
+)
+
+// optMeta returns the first of the optional metadata arguments of the Put
+// variants, if given.
+func optMeta(meta []ObjectMeta) ObjectMeta {
+	if len(meta) == 0 {
+		return ObjectMeta{}
+	}
+	return meta[0]
+}
+
+// backendMeta returns the metadata stored by the Backend for m.
+func (m ObjectMeta) backendMeta(encryption string) map[string]string {
+	meta := map[string]string{
+		metaEncryption: encryption,
+	}
+	if m.ContentType != "" {
+		meta[metaContentType] = m.ContentType
+	}
+	if m.ContentEncoding != "" {
+		meta[metaContentEncoding] = m.ContentEncoding
+	}
+	for key, val := range m.UserMeta {
+		meta[metaUserPrefix+http.CanonicalHeaderKey(key)] = val
+	}
+	return meta
+}
+
+// withCodec returns m with codec appended to its content encoding.
+func (m ObjectMeta) withCodec(codec string) ObjectMeta {
+	switch {
+	case codec == "":
+	case m.ContentEncoding == "":
+		m.ContentEncoding = codec
+	default:
+		m.ContentEncoding += ", " + codec
+	}
+	return m
+}
+
+// replaceMeta returns the Backend metadata src with the metadata given when
+// writing the object replaced by m. The metadata recorded by this package is
+// kept.
+func replaceMeta(src map[string]string, m ObjectMeta) map[string]string {
+	meta := m.withCodec(src[metaCodec]).backendMeta(src[metaEncryption])
+	for _, key := range []string{
+		metaChecksum, metaEncryption, metaCodec, metaFileSize, metaFileModTime,
+		metaEncKeyID, metaEncDataKey,
+	} {
+		if val, ok := src[key]; ok {
+			meta[key] = val
+		} else {
+			delete(meta, key)
+		}
+	}
+	return meta
+}
+
+// setMetaFields sets the fields of info stored in info.Meta.
+func (info *FileInfo) setMetaFields() {
+	info.Checksum = info.Meta[metaChecksum]
+	info.ContentType = info.Meta[metaContentType]
+	info.ContentEncoding = info.Meta[metaContentEncoding]
+	info.Encryption = info.Meta[metaEncryption]
+
+	info.FileSize, _ = strconv.ParseInt(info.Meta[metaFileSize], 10, 64)
+	info.FileModTime, _ = time.Parse(time.RFC3339Nano, info.Meta[metaFileModTime])
+
+	info.UserMeta = nil
+	for key, val := range info.Meta {
+		if !strings.HasPrefix(key, metaUserPrefix) {
+			continue
+		}
+		if info.UserMeta == nil {
+			info.UserMeta = map[string]string{}
+		}
+		info.UserMeta[key[len(metaUserPrefix):]] = val
+	}
+}
@@ -0,0 +1,69 @@
+package objstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestClientMeta(t *testing.T) {
+	cl := NewClientForTesting()
+
+	meta := ObjectMeta{
+		ContentType: "application/json",
+		UserMeta:    map[string]string{"schema-version": "3"},
+	}
+
+	if err := cl.PutBytes([]byte("{}"), testBucket, "m/a", meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutGZ(bytes.NewBufferString("{}"), testBucket, "m/b", meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutNC(bytes.NewBufferString("{}"), testBucket, "m/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	type TestCase struct {
+		Name            string
+		ContentType     string
+		ContentEncoding string
+		Encryption      string
+		SchemaVersion   string
+	}
+
+	cases := []TestCase{
+		{"m/a", "application/json", "", EncryptionAESGCM, "3"},
+		{"m/b", "application/json", "gzip", EncryptionAESGCM, "3"},
+		{"m/c", "", "", EncryptionNone, ""},
+	}
+
+	check := func(info FileInfo, tc TestCase) {
+		if info.Name != tc.Name ||
+			info.ContentType != tc.ContentType ||
+			info.ContentEncoding != tc.ContentEncoding ||
+			info.Encryption != tc.Encryption ||
+			info.UserMeta["Schema-Version"] != tc.SchemaVersion ||
+			len(info.Checksum) != 64 {
+			t.Fatal(info)
+		}
+	}
+
+	for _, tc := range cases {
+		info, err := cl.Stat(testBucket, tc.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(info, tc)
+	}
+
+	l, err := cl.ListMeta(testBucket, "m/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != len(cases) {
+		t.Fatal(l)
+	}
+	for i, tc := range cases {
+		check(l[i], tc)
+	}
+}
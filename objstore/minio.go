@@ -0,0 +1,452 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	minio "github.com/minio/minio-go"
+)
+
+var errInvalidCAFile = errors.New("InvalidCAFile")
+
+// minioMetaPrefix is the canonical prefix of user metadata headers.
+const minioMetaPrefix = "X-Amz-Meta-"
+
+// minioMaxCopySize is the size of the largest object that can be copied in a
+// single request.
+const minioMaxCopySize = 5 << 30
+
+type minioBackend struct {
+	cl *minio.Client
+}
+
+// NewMinioBackend returns a Backend storing objects on the S3 compatible
+// server at host.
+func NewMinioBackend(host, key, secret string) (Backend, error) {
+	return NewMinioBackendWithConfig(Config{
+		Host:   host,
+		Key:    key,
+		Secret: secret,
+	})
+}
+
+// NewMinioBackendWithConfig returns a Backend storing objects on the S3
+// compatible server given by conf. The encryption settings in conf are
+// ignored.
+func NewMinioBackendWithConfig(conf Config) (Backend, error) {
+	cl, err := minio.NewWithRegion(conf.Host, conf.Key, conf.Secret, !conf.DisableTLS, conf.Region)
+	if err != nil {
+		log.Printf("Failed to connect to object store: %v", err)
+		return nil, err
+	}
+
+	transport := conf.Transport
+	if transport == nil && (conf.CAFile != "" || conf.MaxIdleConns != 0 || conf.MaxIdleConnsPerHost != 0) {
+		if transport, err = newMinioTransport(conf); err != nil {
+			return nil, err
+		}
+	}
+	if transport != nil {
+		cl.SetCustomTransport(transport)
+	}
+
+	return &minioBackend{cl: cl}, nil
+}
+
+func newMinioTransport(conf Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if conf.MaxIdleConns != 0 {
+		transport.MaxIdleConns = conf.MaxIdleConns
+	}
+	if conf.MaxIdleConnsPerHost != 0 {
+		transport.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+
+	if conf.CAFile != "" {
+		buf, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			log.Printf("Failed to read CA file %s: %v", conf.CAFile, err)
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			log.Printf("No certificates found in CA file %s", conf.CAFile)
+			return nil, errInvalidCAFile
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *minioBackend) Put(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	r io.Reader,
+	opts BackendPutOptions,
+) error {
+	partSize := opts.PartSize
+	if partSize == 0 {
+		partSize = 1024 * 1024 * 64
+	}
+
+	size := opts.Size
+	if size == 0 {
+		size = -1
+	}
+
+	meta := opts.Meta
+
+	// Metadata is sent before the data, so data fitting in a single part is
+	// buffered in order to send the trailer with it. Otherwise the trailer is
+	// set afterwards.
+	if opts.Trailer != nil && size <= partSize {
+		buf, err := ioutil.ReadAll(io.LimitReader(ctxReader{ctx, r}, partSize+1))
+		if err != nil {
+			log.Printf("Failed to read object %s/%s: %v", bucket, rPath, err)
+			return err
+		}
+
+		if int64(len(buf)) <= partSize {
+			if meta, err = opts.trailerMeta(); err != nil {
+				return err
+			}
+			size = int64(len(buf))
+			opts.Trailer = nil
+		}
+		r = io.MultiReader(bytes.NewReader(buf), r)
+	}
+
+	// With the size known, parts of io.ReaderAt readers are uploaded in
+	// parallel. Otherwise, each part is buffered and uploaded in turn.
+	_, err := be.cl.PutObjectWithContext(ctx, bucket, rPath, r, size, minio.PutObjectOptions{
+		UserMetadata: meta,
+		PartSize:     uint64(partSize),
+		NumThreads:   uint(opts.Concurrency),
+	})
+	if err != nil {
+		log.Printf("Failed to put object %s/%s: %v", bucket, rPath, err)
+		return convertError(err)
+	}
+
+	if opts.Trailer == nil {
+		return nil
+	}
+	if meta, err = opts.trailerMeta(); err != nil {
+		return err
+	}
+	return be.SetMeta(ctx, bucket, rPath, meta)
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *minioBackend) SetMeta(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	meta map[string]string,
+) error {
+	// The minio client can't cancel a copy in progress.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Metadata can only be changed by copying the object onto itself.
+	// Compose, unlike copy, also handles objects larger than 5 GiB.
+	src := minio.NewSourceInfo(bucket, rPath, nil)
+
+	dst, err := minio.NewDestinationInfo(bucket, rPath, nil, meta)
+	if err != nil {
+		log.Printf("Failed to create destination: %v", err)
+		return convertError(err)
+	}
+
+	if err := be.cl.ComposeObject(dst, []minio.SourceInfo{src}); err != nil {
+		log.Printf("Failed to set metadata of %s/%s: %v", bucket, rPath, err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *minioBackend) Get(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	io.ReadCloser,
+	FileInfo,
+	error,
+) {
+	obj, err := be.cl.GetObjectWithContext(ctx, bucket, rPath, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("Failed to get object: %v", err)
+		return nil, FileInfo{}, convertError(err)
+	}
+
+	// Stat returns the response headers of the request made for the first
+	// read.
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		log.Printf("Failed to get object: %v", err)
+		return nil, FileInfo{}, convertError(err)
+	}
+
+	return obj, minioFileInfo(info), nil
+}
+
+func (be *minioBackend) GetRange(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	opts := minio.GetObjectOptions{}
+
+	var err error
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+	if err != nil {
+		log.Printf("Failed to set range: %v", err)
+		return nil, err
+	}
+
+	obj, err := be.cl.GetObjectWithContext(ctx, bucket, rPath, opts)
+	if err != nil {
+		log.Printf("Failed to get object: %v", err)
+		return nil, convertError(err)
+	}
+	return obj, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *minioBackend) Stat(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	FileInfo,
+	error,
+) {
+	info, err := be.cl.StatObjectWithContext(ctx, bucket, rPath, minio.StatObjectOptions{})
+	if err != nil {
+		log.Printf("Failed to stat object: %v", err)
+		return FileInfo{}, convertError(err)
+	}
+	return minioFileInfo(info), nil
+}
+
+// minioFileInfo converts info, including the user metadata if present.
+func minioFileInfo(info minio.ObjectInfo) FileInfo {
+	fi := FileInfo{
+		Name:    info.Key,
+		ModTime: info.LastModified.UTC(),
+		Size:    info.Size,
+	}
+
+	for key, vals := range info.Metadata {
+		if !strings.HasPrefix(key, minioMetaPrefix) || len(vals) == 0 {
+			continue
+		}
+		if fi.Meta == nil {
+			fi.Meta = map[string]string{}
+		}
+		fi.Meta[key[len(minioMetaPrefix):]] = vals[0]
+	}
+
+	return fi
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *minioBackend) List(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	opts BackendListOptions,
+) (
+	[]FileInfo,
+	bool,
+	error,
+) {
+	delimiter := opts.Delimiter
+	if opts.Recursive {
+		delimiter = ""
+	} else if delimiter == "" {
+		delimiter = "/"
+	}
+
+	core := minio.Core{Client: be.cl}
+	l := []FileInfo{}
+	token := ""
+	truncated := false
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+
+		maxKeys := 1000
+		if opts.MaxKeys > 0 && opts.MaxKeys-len(l) < maxKeys {
+			maxKeys = opts.MaxKeys - len(l)
+		}
+
+		res, err := core.ListObjectsV2(
+			bucket, prefix, token, false, delimiter, maxKeys, opts.StartAfter)
+		if err != nil {
+			log.Printf("Error listing objects: %v", err)
+			return nil, false, convertError(err)
+		}
+
+		// Objects and common prefixes are each sorted, but returned
+		// separately.
+		objs, prefixes := res.Contents, res.CommonPrefixes
+		for len(objs) > 0 || len(prefixes) > 0 {
+			var info FileInfo
+			if len(prefixes) == 0 || len(objs) > 0 && objs[0].Key < prefixes[0].Prefix {
+				info = FileInfo{
+					Name:    objs[0].Key,
+					ModTime: objs[0].LastModified.UTC(),
+					Size:    objs[0].Size,
+				}
+				objs = objs[1:]
+			} else {
+				info = FileInfo{Name: prefixes[0].Prefix, IsDir: true}
+				prefixes = prefixes[1:]
+			}
+
+			// A common prefix containing StartAfter is returned again.
+			if info.Name > opts.StartAfter {
+				l = append(l, info)
+			}
+		}
+
+		truncated = res.IsTruncated
+		if !truncated || opts.MaxKeys > 0 && len(l) >= opts.MaxKeys {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+
+	if opts.MaxKeys > 0 && len(l) > opts.MaxKeys {
+		l = l[:opts.MaxKeys]
+		truncated = true
+	}
+	return l, truncated, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *minioBackend) Delete(
+	ctx context.Context,
+	bucket string,
+	rPaths ...string,
+) error {
+	rPathCh := make(chan string, len(rPaths))
+	for _, s := range rPaths {
+		rPathCh <- s
+	}
+	close(rPathCh)
+
+	errs := map[string]error{}
+	for rErr := range be.cl.RemoveObjectsWithContext(ctx, bucket, rPathCh) {
+		if rErr.Err != nil {
+			log.Printf("Failed to delete object %s: %v", rErr.ObjectName, rErr.Err)
+			errs[rErr.ObjectName] = convertError(rErr.Err)
+		}
+	}
+	return newMultiError(errs)
+}
+
+// ----------------------------------------------------------------------------
+
+func (be *minioBackend) Copy(
+	ctx context.Context,
+	srcBucket,
+	srcPath,
+	dstBucket,
+	dstPath string,
+	meta map[string]string,
+) error {
+	// The minio client can't cancel a copy in progress.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The size decides how the object is copied. Stat'ing also reports a
+	// missing source as such, which compose doesn't.
+	info, err := be.Stat(ctx, srcBucket, srcPath)
+	if err != nil {
+		return err
+	}
+
+	// Source object.
+	src := minio.NewSourceInfo(srcBucket, srcPath, nil)
+
+	// Destination object. Compose doesn't reliably copy the metadata of the
+	// source, so it's passed explicitly.
+	if meta == nil && info.Size > minioMaxCopySize {
+		meta = info.Meta
+	}
+	dst, err := minio.NewDestinationInfo(dstBucket, dstPath, nil, meta)
+	if err != nil {
+		log.Printf("Failed to create destination: %v", err)
+		return convertError(err)
+	}
+
+	// Objects larger than 5 GiB must be copied in parts.
+	if info.Size > minioMaxCopySize {
+		err = be.cl.ComposeObject(dst, []minio.SourceInfo{src})
+	} else {
+		err = be.cl.CopyObject(dst, src)
+	}
+	if err != nil {
+		log.Printf("Failed to copy %s/%s -> %s/%s: %v",
+			srcBucket, srcPath, dstBucket, dstPath, err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// isTransientMinioError reports whether err is an error response from the
+// server that may go away when retrying.
+func isTransientMinioError(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	switch resp.Code {
+	case "InternalError", "ServiceUnavailable", "SlowDown", "RequestTimeout":
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == 429
+}
@@ -1,47 +1,68 @@
 package objstore
 
 import (
+	"context"
 	"io"
-	"log"
-	"os"
-
-	minio "github.com/minio/minio-go"
 )
 
 // ----------------------------------------------------------------------------
 
-func (cl *Client) PutNC(r io.Reader, bucket, rPath string) error {
-	_, err := cl.cl.PutObject(bucket, rPath, r, -1, minio.PutObjectOptions{
-		PartSize: 1024 * 1024 * 64,
+// PutNC: Like Put, but doesn't encrypt the data. This is PutObject with
+// NoEncrypt set. Get reads the object back unencrypted.
+func (cl *Client) PutNC(r io.Reader, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutNCContext(context.Background(), r, bucket, rPath, meta...)
+}
+
+func (cl *Client) PutNCContext(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutObject(ctx, r, bucket, rPath, PutOptions{
+		ObjectMeta: optMeta(meta),
+		NoEncrypt:  true,
 	})
-	if err != nil {
-		log.Printf("Failed to put object %s/%s: %v", bucket, rPath, err)
-	}
-	return convertError(err)
 }
 
 // ----------------------------------------------------------------------------
 
 // PutGZ: Like PutNC, but compresses the data stream before sending.
-func (cl *Client) PutNCGZ(r io.Reader, bucket, rPath string) error {
-	return cl.PutNC(gzipReader(r), bucket, rPath)
+func (cl *Client) PutNCGZ(r io.Reader, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutNCGZContext(context.Background(), r, bucket, rPath, meta...)
 }
 
-// ----------------------------------------------------------------------------
-
-func (cl *Client) PutNCFileGZ(lPath, bucket, rPath string) error {
-	return cl.withRetry(func() error {
-		return cl.putNCFileGZ(lPath, bucket, rPath)
+func (cl *Client) PutNCGZContext(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutObject(ctx, r, bucket, rPath, PutOptions{
+		ObjectMeta: optMeta(meta),
+		NoEncrypt:  true,
+		Codec:      CodecGzip,
 	})
 }
 
-func (cl *Client) putNCFileGZ(lPath, bucket, rPath string) error {
-	f, err := os.Open(lPath)
-	if err != nil {
-		log.Printf("Failed to open file: %v", lPath)
-		return err
-	}
-	defer f.Close()
+// ----------------------------------------------------------------------------
+
+func (cl *Client) PutNCFileGZ(lPath, bucket, rPath string, meta ...ObjectMeta) error {
+	return cl.PutNCFileGZContext(context.Background(), lPath, bucket, rPath, meta...)
+}
 
-	return cl.PutNCGZ(f, bucket, rPath)
+func (cl *Client) PutNCFileGZContext(
+	ctx context.Context,
+	lPath,
+	bucket,
+	rPath string,
+	meta ...ObjectMeta,
+) error {
+	return cl.PutObjectFile(ctx, lPath, bucket, rPath, PutOptions{
+		ObjectMeta: optMeta(meta),
+		NoEncrypt:  true,
+		Codec:      CodecGzip,
+	})
 }
@@ -0,0 +1,701 @@
+package objstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PutOptions configures PutObject and PutObjectFile. The zero value encrypts
+// the data without compressing it.
+type PutOptions struct {
+	// Metadata stored with the object. If Codec is set, it's appended to
+	// ContentEncoding.
+	ObjectMeta
+
+	// NoEncrypt stores the data unencrypted.
+	NoEncrypt bool
+
+	// Codec, if set, compresses the data with the named codec, e.g.
+	// CodecGzip, at the given level. See RegisterCodec.
+	Codec      string
+	CodecLevel int
+
+	// PartSize is the size of multipart upload parts. Default: 64 MiB.
+	PartSize int64
+
+	// Concurrency is the number of parts uploaded in parallel. It only
+	// applies to uncompressed data read from an io.ReaderAt that is also an
+	// io.Seeker, such as a file, as the size must be known. Default: 4.
+	Concurrency int
+
+	// Retry, if set, replaces the client's retry policy.
+	Retry *RetryPolicy
+}
+
+// GetOptions configures GetObject and GetObjectFile. Encryption and
+// compression are undone as recorded when the object was written.
+type GetOptions struct {
+	// Raw returns the data without decompressing it. The checksum isn't
+	// verified in that case.
+	Raw bool
+
+	// NoEncrypt and Codec give how the object was written if that wasn't
+	// recorded, i.e. for objects written by older versions of this package.
+	NoEncrypt bool
+	Codec     string
+
+	// Concurrency is the number of ranges of PartSize bytes that
+	// GetObjectFile downloads in parallel. Compressed objects are always
+	// downloaded in one go. Defaults: 4 and 64 MiB.
+	Concurrency int
+	PartSize    int64
+
+	// Retry, if set, replaces the client's retry policy.
+	Retry *RetryPolicy
+}
+
+// ----------------------------------------------------------------------------
+
+// PutObject writes the data read from r to bucket/rPath as configured by
+// opts. Failed uploads are only retried if r is an io.Seeker, or if nothing
+// was read from it yet, and never for errors returned by r. If r is also an
+// io.ReaderAt, it's read with ReadAt, leaving its offset alone, so that parts
+// can be uploaded in parallel.
+func (cl *Client) PutObject(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath string,
+	opts PutOptions,
+) error {
+	return cl.put(ctx, r, bucket, rPath, nil, opts)
+}
+
+// put is PutObject. If file is set, it's the local file r reads from, whose
+// size and modification time are recorded.
+func (cl *Client) put(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath string,
+	file os.FileInfo,
+	opts PutOptions,
+) error {
+	sr, err := sizedSection(r)
+	if err != nil {
+		return err
+	}
+
+	// The checksum of data of known size is computed up front, so that it's
+	// stored along with the data rather than once the upload is done.
+	sum := ""
+	if sr != nil {
+		if sum, err = seekableChecksum(r); err != nil {
+			return err
+		}
+	}
+
+	if sr != nil && opts.Codec == "" {
+		return cl.withRetryPolicy(ctx, opts.Retry, func() error {
+			// A failed attempt may have read from the section, so each
+			// attempt reads from a section of its own.
+			section := io.NewSectionReader(readerAtErrors{sr}, 0, sr.Size())
+			return cl.putCompressed(ctx, section, bucket, rPath, sum, nil, file, opts)
+		})
+	}
+
+	return cl.putWithRetry(ctx, opts.Retry, r, func(r io.Reader) error {
+		return cl.putObject(ctx, r, bucket, rPath, sum, file, opts)
+	})
+}
+
+// putObject writes the data read from r to bucket/rPath along with its
+// metadata and SHA-256 checksum. If sum is given, it's the checksum computed
+// up front. Otherwise the checksum is computed while uploading. See put for
+// file.
+func (cl *Client) putObject(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath,
+	sum string,
+	file os.FileInfo,
+	opts PutOptions,
+) error {
+	var h hash.Hash
+	if sum == "" {
+		h = sha256.New()
+		r = io.TeeReader(r, h)
+	}
+
+	compressed, err := compressReader(opts.Codec, opts.CodecLevel, r)
+	if err != nil {
+		return err
+	}
+	defer compressed.Close()
+
+	return cl.putCompressed(ctx, compressed, bucket, rPath, sum, h, file, opts)
+}
+
+// putCompressed is putObject for data r already compressed as configured by
+// opts. If h is nil, sum is the checksum of the uncompressed data. Otherwise h
+// is its hash, which must be complete once r has been read to the end, and
+// the checksum is stored as trailing metadata, see BackendPutOptions.
+//
+// If r is an io.SectionReader, its size is known, and parts are uploaded in
+// parallel.
+func (cl *Client) putCompressed(
+	ctx context.Context,
+	r io.Reader,
+	bucket,
+	rPath,
+	sum string,
+	h hash.Hash,
+	file os.FileInfo,
+	opts PutOptions,
+) error {
+	meta := opts.ObjectMeta.withCodec(opts.Codec)
+
+	src := r
+	sr, _ := r.(*io.SectionReader)
+	encryption := EncryptionNone
+	var encMeta map[string]string
+
+	if !opts.NoEncrypt {
+		var err error
+		if sr != nil {
+			sr, encMeta, err = encryptSection(cl.keyring(), sr)
+			src = sr
+		} else {
+			src, encMeta, err = encryptReader(cl.keyring(), src)
+		}
+		if err != nil {
+			return err
+		}
+		encryption = EncryptionAESGCM
+	}
+
+	beOpts := BackendPutOptions{
+		Meta:        meta.backendMeta(encryption),
+		PartSize:    opts.PartSize,
+		Concurrency: opts.Concurrency,
+	}
+	if sr != nil {
+		beOpts.Size = sr.Size()
+	}
+	for k, v := range encMeta {
+		beOpts.Meta[k] = v
+	}
+	if opts.Codec != "" {
+		beOpts.Meta[metaCodec] = opts.Codec
+	}
+	if h == nil {
+		beOpts.Meta[metaChecksum] = sum
+	} else {
+		beOpts.Trailer = func() (map[string]string, error) {
+			return map[string]string{metaChecksum: hex.EncodeToString(h.Sum(nil))}, nil
+		}
+	}
+	if file != nil {
+		beOpts.Meta[metaFileSize] = strconv.FormatInt(file.Size(), 10)
+		beOpts.Meta[metaFileModTime] = file.ModTime().UTC().Format(time.RFC3339Nano)
+	}
+
+	return cl.Backend.Put(ctx, bucket, rPath, src, beOpts)
+}
+
+// PutObjectFile writes the local file lPath to bucket/rPath as configured by
+// opts. The file's size and modification time are recorded, see FileInfo.
+func (cl *Client) PutObjectFile(
+	ctx context.Context,
+	lPath,
+	bucket,
+	rPath string,
+	opts PutOptions,
+) error {
+	f, err := os.Open(lPath)
+	if err != nil {
+		log.Printf("Failed to open file: %v", lPath)
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		log.Printf("Failed to stat file %s: %v", lPath, err)
+		return err
+	}
+
+	return cl.put(ctx, f, bucket, rPath, fi, opts)
+}
+
+// sizedSection returns the rest of r as a section if r is an io.ReaderAt and
+// an io.Seeker, or nil.
+func sizedSection(r io.Reader) (*io.SectionReader, error) {
+	ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil, nil
+	}
+
+	offset, err := ra.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Not seekable after all, e.g. a pipe.
+		return nil, nil
+	}
+	end, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Printf("Failed to seek: %v", err)
+		return nil, err
+	}
+	if _, err := ra.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("Failed to seek: %v", err)
+		return nil, err
+	}
+
+	return io.NewSectionReader(ra, offset, end-offset), nil
+}
+
+// ----------------------------------------------------------------------------
+
+// GetObject returns the data of bucket/rPath, decrypted and decompressed as
+// configured by opts. The checksum, if recorded, is verified once the data has
+// been read to the end. Reading from the returned object fails once ctx is
+// done.
+func (cl *Client) GetObject(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	opts GetOptions,
+) (
+	io.ReadCloser,
+	error,
+) {
+	var r io.ReadCloser
+	err := cl.withRetryPolicy(ctx, opts.Retry, func() (err error) {
+		r, err = cl.getObject(ctx, bucket, rPath, opts)
+		return err
+	})
+	return r, err
+}
+
+func (cl *Client) getObject(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	opts GetOptions,
+) (
+	io.ReadCloser,
+	error,
+) {
+	obj, info, err := cl.Backend.Get(ctx, bucket, rPath)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, codec := objectEncoding(info, opts)
+
+	r := io.Reader(obj)
+	if encryption != EncryptionNone {
+		if r, err = decryptReader(cl.keyring(), info.Meta, obj); err != nil {
+			obj.Close()
+			return nil, convertError(err)
+		}
+	}
+
+	if opts.Raw {
+		codec = ""
+	}
+
+	decompressed, err := decompressReader(codec, r)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	r = decompressed
+
+	if sum := info.Meta[metaChecksum]; sum != "" && !opts.Raw {
+		r = newChecksumReader(r, sum)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: r,
+		Closer: closerFunc(func() error {
+			decompressed.Close()
+			return obj.Close()
+		}),
+	}, nil
+}
+
+// objectEncoding returns how the object with the given information was
+// encrypted and compressed.
+func objectEncoding(info FileInfo, opts GetOptions) (encryption, codec string) {
+	encryption = info.Meta[metaEncryption]
+	codec = info.Meta[metaCodec]
+	if encryption == "" {
+		// Not recorded, so rely on the caller.
+		encryption = EncryptionAESGCM
+		if opts.NoEncrypt {
+			encryption = EncryptionNone
+		}
+		codec = opts.Codec
+	}
+	return encryption, codec
+}
+
+// GetObjectFile writes the data of bucket/rPath to the local file lPath like
+// GetObject. The data is written to a temporary file next to lPath, which
+// replaces lPath once it's complete, verified and synced to disk. Interrupted
+// downloads of uncompressed objects with a recorded checksum are resumed from
+// the temporary file, also by retries.
+func (cl *Client) GetObjectFile(
+	ctx context.Context,
+	bucket,
+	rPath,
+	lPath string,
+	opts GetOptions,
+) error {
+	return cl.withRetryPolicy(ctx, opts.Retry, func() error {
+		return cl.getObjectFile(ctx, bucket, rPath, lPath, opts)
+	})
+}
+
+func (cl *Client) getObjectFile(
+	ctx context.Context,
+	bucket,
+	rPath,
+	lPath string,
+	opts GetOptions,
+) error {
+	info, err := cl.Backend.Stat(ctx, bucket, rPath)
+	if err != nil {
+		return err
+	}
+
+	// Uncompressed data can be read in ranges, and can therefore be resumed
+	// if the checksum identifies it and allows verifying it.
+	_, codec := objectEncoding(info, opts)
+	sum := info.Meta[metaChecksum]
+	ranged := codec == "" || opts.Raw
+	resume := codec == "" && sum != ""
+
+	f, offset, err := openPartFile(lPath, sum, resume)
+	if err != nil {
+		return err
+	}
+	partPath := f.Name()
+
+	if ranged {
+		err = cl.getFileRanges(ctx, bucket, rPath, f, info, offset, opts)
+	} else {
+		err = cl.getFileStream(ctx, bucket, rPath, f, opts)
+	}
+	if err == nil && resume {
+		err = verifyFile(f, sum)
+	}
+	if err == nil {
+		if err = f.Sync(); err != nil {
+			log.Printf("Failed to sync file %s: %v", partPath, err)
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		// Keep what was downloaded to resume from, unless it's wrong.
+		if !resume || err == ErrChecksumMismatch {
+			os.Remove(partPath)
+		}
+		if err == ErrChecksumMismatch && offset > 0 {
+			// The resumed part may have been damaged, e.g. by a crash.
+			log.Printf("Restarting download of %s/%s", bucket, rPath)
+			return cl.getObjectFile(ctx, bucket, rPath, lPath, opts)
+		}
+		return err
+	}
+
+	if err := os.Rename(partPath, lPath); err != nil {
+		log.Printf("Failed to rename %s to %s: %v", partPath, lPath, err)
+		return err
+	}
+
+	removePartFiles(lPath)
+	return nil
+}
+
+// partFilePath returns the path of the temporary file used to resume
+// downloads to lPath of the data with the given checksum. The checksum is part
+// of the name, so that only downloads of the same data are resumed.
+func partFilePath(lPath, sum string) string {
+	// The checksum is read from metadata, so don't use it verbatim.
+	h := sha256.Sum256([]byte(sum))
+	name := "." + filepath.Base(lPath) + "." + hex.EncodeToString(h[:8]) + ".part"
+	return filepath.Join(filepath.Dir(lPath), name)
+}
+
+// openPartFile opens the temporary file used to download the data with the
+// given checksum to lPath. If resume is set, it's the file returned by
+// partFilePath, and its size is returned to resume from. Otherwise it's a new
+// file, so that concurrent downloads to lPath don't share it.
+func openPartFile(lPath, sum string, resume bool) (*os.File, int64, error) {
+	if !resume {
+		f, err := ioutil.TempFile(filepath.Dir(lPath), "."+filepath.Base(lPath)+".tmp-")
+		if err != nil {
+			log.Printf("Failed to create file: %v", err)
+			return nil, 0, err
+		}
+
+		// Temporary files are only readable by the owner, unlike files
+		// created as usual.
+		if err := f.Chmod(0644); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			log.Printf("Failed to chmod file %s: %v", f.Name(), err)
+			return nil, 0, err
+		}
+		return f, 0, nil
+	}
+
+	path := partFilePath(lPath, sum)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		log.Printf("Failed to create file: %v", err)
+		return nil, 0, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		log.Printf("Failed to stat file %s: %v", path, err)
+		return nil, 0, err
+	}
+
+	if fi.Size() > 0 {
+		log.Printf("Resuming download to %s at %d bytes", path, fi.Size())
+	}
+	return f, fi.Size(), nil
+}
+
+// removePartFiles removes the temporary files left by interrupted downloads
+// to lPath, e.g. of older versions of the object, once lPath is complete.
+func removePartFiles(lPath string) {
+	dir := filepath.Dir(lPath)
+	d, err := os.Open(dir)
+	if err != nil {
+		log.Printf("Failed to open directory %s: %v", dir, err)
+		return
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		log.Printf("Failed to read directory %s: %v", dir, err)
+		return
+	}
+
+	// Only names of the same form as partFilePath's are removed.
+	prefix := "." + filepath.Base(lPath) + "."
+	size := len(filepath.Base(partFilePath(lPath, "")))
+	for _, name := range names {
+		if len(name) == size && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".part") {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				log.Printf("Failed to remove %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// verifyFile checks that the data in f has the given checksum.
+func verifyFile(f *os.File, sum string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Printf("Failed to seek in file: %v", err)
+		return err
+	}
+
+	actual, err := seekableChecksum(f)
+	if err != nil {
+		return err
+	}
+	if actual != sum {
+		log.Printf("Checksum mismatch for %s", f.Name())
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// getFileStream writes the data of bucket/rPath to f, reading it in one go.
+func (cl *Client) getFileStream(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	f *os.File,
+	opts GetOptions,
+) error {
+	r, err := cl.getObject(ctx, bucket, rPath, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		log.Printf("Failed to copy object to file: %v", err)
+		return err
+	}
+	return nil
+}
+
+// getFileRanges writes the data of bucket/rPath starting at offset to the
+// same offset in f. Large objects are read in ranges of opts.PartSize bytes,
+// opts.Concurrency of them in parallel. If that fails, f is truncated to the
+// part that was written completely.
+func (cl *Client) getFileRanges(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	f *os.File,
+	info FileInfo,
+	offset int64,
+	opts GetOptions,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rr, err := cl.newRangeReader(ctx, bucket, rPath, info, opts)
+	if err != nil {
+		return err
+	}
+
+	if offset > rr.size {
+		if err := f.Truncate(0); err != nil {
+			log.Printf("Failed to truncate file: %v", err)
+			return err
+		}
+		offset = 0
+	}
+
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = 64 * 1024 * 1024
+	}
+
+	if opts.Concurrency == 1 || rr.size-offset <= partSize {
+		return getFileRange(ctx, rr, f, offset, -1)
+	}
+
+	var offsets []int64
+	for o := offset; o < rr.size; o += partSize {
+		offsets = append(offsets, o)
+	}
+
+	idxCh := make(chan int, len(offsets))
+	for i := range offsets {
+		idxCh <- i
+	}
+	close(idxCh)
+
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = 4
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		done     = make([]bool, len(offsets))
+		firstErr error
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxCh {
+				err := getFileRange(ctx, rr, f, offsets[idx], partSize)
+
+				mu.Lock()
+				done[idx] = err == nil
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr == nil {
+		return nil
+	}
+
+	// Keep the ranges up to the first incomplete one, to resume from.
+	for idx, ok := range done {
+		if !ok {
+			if err := f.Truncate(offsets[idx]); err != nil {
+				log.Printf("Failed to truncate file: %v", err)
+			}
+			break
+		}
+	}
+	return firstErr
+}
+
+// getFileRange writes length bytes of the data read by rr starting at offset
+// to the same offset in f, or the rest of the data if length is negative.
+func getFileRange(
+	ctx context.Context,
+	rr *rangeReader,
+	f *os.File,
+	offset,
+	length int64,
+) error {
+	r, err := rr.open(ctx, offset, length)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w := &offsetWriter{w: f, offset: offset}
+	if _, err := io.Copy(w, r); err != nil {
+		log.Printf("Failed to copy object range to file: %v", err)
+		return err
+	}
+	return nil
+}
+
+// offsetWriter writes to w starting at offset.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// ----------------------------------------------------------------------------
+
+type closerFunc func() error
+
+func (fn closerFunc) Close() error {
+	return fn()
+}
@@ -0,0 +1,343 @@
+package objstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestClientPutGetObject(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	data := []byte("Some data. Some data. Some data.")
+
+	type TestCase struct {
+		Name string
+		Opts PutOptions
+	}
+
+	cases := []TestCase{
+		{"plain", PutOptions{}},
+		{"gz", PutOptions{Codec: CodecGzip}},
+		{"nc", PutOptions{NoEncrypt: true}},
+		{"ncgz", PutOptions{NoEncrypt: true, Codec: CodecGzip, PartSize: 1024 * 1024 * 5}},
+	}
+
+	for _, tc := range cases {
+		err := cl.PutObject(ctx, bytes.NewReader(data), testBucket, tc.Name, tc.Opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Get undoes what was done on Put.
+		r, err := cl.Get(testBucket, tc.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(tc.Name, err)
+		}
+		if !bytes.Equal(buf, data) {
+			t.Fatal(tc.Name, string(buf))
+		}
+
+		if err := cl.GetFileGZ(testBucket, tc.Name, "files/out/"+tc.Name); err != nil {
+			t.Fatal(tc.Name, err)
+		}
+		if buf, err := ioutil.ReadFile("files/out/" + tc.Name); err != nil || !bytes.Equal(buf, data) {
+			t.Fatal(tc.Name, err)
+		}
+	}
+
+	// Raw doesn't decompress.
+	r, err := cl.GetObject(ctx, testBucket, "ncgz", GetOptions{Raw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := ioutil.ReadAll(gr); err != nil || !bytes.Equal(buf, data) {
+		t.Fatal(err)
+	}
+
+	err = cl.PutObject(ctx, bytes.NewReader(data), testBucket, "x", PutOptions{Codec: "lzw"})
+	if err != ErrUnknownCodec {
+		t.Fatal(err)
+	}
+}
+
+func TestClientGetObjectUnrecorded(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	// Written without metadata, like by older versions.
+	gz, err := compressReader(CodecGzip, 0, bytes.NewBufferString("Some data."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	err = cl.Backend.Put(ctx, testBucket, "old.gz", gz, BackendPutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := cl.GetObject(ctx, testBucket, "old.gz", GetOptions{
+		NoEncrypt: true,
+		Codec:     CodecGzip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if buf, err := ioutil.ReadAll(r); err != nil || string(buf) != "Some data." {
+		t.Fatal(string(buf), err)
+	}
+}
+
+func TestClientParallelTransfer(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	data := make([]byte, 5*encSegmentSize+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := ioutil.WriteFile("files/out/src", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	putOpts := PutOptions{Concurrency: 3}
+	if err := cl.PutObjectFile(ctx, "files/out/src", testBucket, "enc", putOpts); err != nil {
+		t.Fatal(err)
+	}
+	putOpts.NoEncrypt = true
+	if err := cl.PutObjectFile(ctx, "files/out/src", testBucket, "plain", putOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	// An object in the legacy format, without metadata.
+	block, err := aes.NewCipher(testEncKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, block.BlockSize())
+	enc := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(enc, data)
+	legacy := bytes.NewReader(append(iv, enc...))
+	if err := cl.Backend.Put(ctx, testBucket, "legacy", legacy, BackendPutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	getOpts := GetOptions{Concurrency: 3, PartSize: encSegmentSize/2 + 1}
+
+	for _, rPath := range []string{"enc", "plain", "legacy"} {
+		if err := cl.GetObjectFile(ctx, testBucket, rPath, "files/out/dst", getOpts); err != nil {
+			t.Fatal(rPath, err)
+		}
+		if !pathsMatch("files/out/src", "files/out/dst") {
+			t.Fatal(rPath)
+		}
+	}
+
+	// The checksum is verified.
+	info, err := cl.Backend.Stat(ctx, testBucket, "enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Meta[metaChecksum] = "bad"
+	if err := cl.Backend.SetMeta(ctx, testBucket, "enc", info.Meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.GetObjectFile(ctx, testBucket, "enc", "files/out/dst", getOpts); err != ErrChecksumMismatch {
+		t.Fatal(err)
+	}
+
+	// Concurrent downloads of compressed objects, which can't be resumed,
+	// don't share a temporary file.
+	putOpts.Codec = CodecGzip
+	if err := cl.PutObjectFile(ctx, "files/out/src", testBucket, "gz", putOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cl.GetObjectFile(ctx, testBucket, "gz", "files/out/dst", getOpts); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !pathsMatch("files/out/src", "files/out/dst") {
+		t.Fatal("gz")
+	}
+	matches, err := filepath.Glob("files/out/.dst.*")
+	if err != nil || len(matches) != 0 {
+		t.Fatal(matches, err)
+	}
+}
+
+// rangeBackend records the offsets read with GetRange, failing reads at or
+// beyond FailAt if it's set.
+type rangeBackend struct {
+	Backend
+
+	mu      sync.Mutex
+	Offsets []int64
+	FailAt  int64
+}
+
+func (be *rangeBackend) GetRange(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	be.mu.Lock()
+	be.Offsets = append(be.Offsets, offset)
+	fail := be.FailAt > 0 && offset >= be.FailAt
+	be.mu.Unlock()
+
+	if fail {
+		return nil, syscall.ECONNRESET
+	}
+	return be.Backend.GetRange(ctx, bucket, rPath, offset, length)
+}
+
+func TestClientGetObjectFileResume(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	be := &rangeBackend{Backend: cl.Backend}
+	cl.Backend = be
+
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(4)).Read(data)
+	h := sha256.Sum256(data)
+	sum := hex.EncodeToString(h[:])
+
+	err := cl.PutObject(ctx, bytes.NewReader(data), testBucket, "plain", PutOptions{NoEncrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lPath := "files/out/dst"
+	partPath := partFilePath(lPath, sum)
+	noRetry := &RetryPolicy{MaxAttempts: 1}
+
+	checkDone := func() {
+		t.Helper()
+		buf, err := ioutil.ReadFile(lPath)
+		if err != nil || !bytes.Equal(buf, data) {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+
+	// A partial download is resumed where it stopped.
+	if err := ioutil.WriteFile(partPath, data[:3000], 0600); err != nil {
+		t.Fatal(err)
+	}
+	be.Offsets = nil
+	if err := cl.GetObjectFile(ctx, testBucket, "plain", lPath, GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkDone()
+	if len(be.Offsets) != 1 || be.Offsets[0] != 3000 {
+		t.Fatal(be.Offsets)
+	}
+
+	// Partial downloads of other data are removed.
+	oldPartPath := partFilePath(lPath, "old")
+	if err := ioutil.WriteFile(oldPartPath, data[:3000], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.GetObjectFile(ctx, testBucket, "plain", lPath, GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkDone()
+	if _, err := os.Stat(oldPartPath); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	// A damaged partial download is started over.
+	if err := ioutil.WriteFile(partPath, make([]byte, 3000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.GetObjectFile(ctx, testBucket, "plain", lPath, GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkDone()
+
+	// A failed parallel download keeps the ranges up to the first failed one.
+	os.Remove(lPath)
+	be.FailAt = 5000
+	opts := GetOptions{Concurrency: 2, PartSize: 1000, Retry: noRetry}
+	if err := cl.GetObjectFile(ctx, testBucket, "plain", lPath, opts); err != syscall.ECONNRESET {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lPath); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(partPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size()%1000 != 0 || fi.Size() > 5000 {
+		t.Fatal(fi.Size())
+	}
+
+	be.FailAt = 0
+	be.Offsets = nil
+	if err := cl.GetObjectFile(ctx, testBucket, "plain", lPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	checkDone()
+	for _, offset := range be.Offsets {
+		if offset < fi.Size() {
+			t.Fatal(be.Offsets)
+		}
+	}
+
+	// A failed download leaves an existing file alone.
+	info, err := cl.Backend.Stat(ctx, testBucket, "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Meta[metaChecksum] = "bad"
+	if err := cl.Backend.SetMeta(ctx, testBucket, "plain", info.Meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.GetObjectFile(ctx, testBucket, "plain", lPath, GetOptions{}); err != ErrChecksumMismatch {
+		t.Fatal(err)
+	}
+	checkDone()
+	if _, err := os.Stat(partFilePath(lPath, "bad")); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,298 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// deleteBatchSize is the number of objects DeletePrefix deletes per request,
+// which is the most S3 accepts.
+const deleteBatchSize = 1000
+
+// PrefixOptions configures DeletePrefix, CopyPrefix and MovePrefix.
+type PrefixOptions struct {
+	Workers int  // Number of objects processed concurrently. Default: 8.
+	DryRun  bool // Report what would be done without changing anything.
+}
+
+// PrefixReport lists the objects processed by DeletePrefix, CopyPrefix or
+// MovePrefix by their source path. In a dry run, it lists what would have been
+// done.
+type PrefixReport struct {
+	Done   []string         // Objects processed successfully.
+	Failed map[string]error // Objects that couldn't be processed.
+}
+
+// Err returns a *MultiError for the failed objects, or nil if none failed.
+func (r PrefixReport) Err() error {
+	return newMultiError(r.Failed)
+}
+
+// ----------------------------------------------------------------------------
+
+// DeletePrefix deletes all objects in bucket below prefix.
+//
+// The returned error is only set if the objects couldn't be listed, in which
+// case the report covers the objects listed before. Errors for individual
+// objects are returned in the report.
+func (cl *Client) DeletePrefix(
+	bucket,
+	prefix string,
+	opts PrefixOptions,
+) (
+	PrefixReport,
+	error,
+) {
+	return cl.DeletePrefixContext(context.Background(), bucket, prefix, opts)
+}
+
+// DeletePrefixContext is like DeletePrefix. Once ctx is done, the objects
+// listed but not yet processed are reported as failed with ctx's error. If
+// the listing wasn't complete, ctx's error is returned, and the report
+// doesn't cover the objects that weren't listed.
+func (cl *Client) DeletePrefixContext(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	opts PrefixOptions,
+) (
+	PrefixReport,
+	error,
+) {
+	return cl.eachPrefix(ctx, bucket, prefix, false, deleteBatchSize, opts, func(rPaths []string) error {
+		return cl.DeleteContext(ctx, bucket, rPaths...)
+	})
+}
+
+// CopyPrefix copies all objects in srcBucket below srcPrefix along with their
+// metadata to dstBucket, replacing srcPrefix with dstPrefix in their paths.
+// Existing objects are replaced. The buckets may be the same.
+//
+// The returned error is only set if the objects couldn't be listed, see
+// DeletePrefix.
+func (cl *Client) CopyPrefix(
+	srcBucket,
+	srcPrefix,
+	dstBucket,
+	dstPrefix string,
+	opts PrefixOptions,
+) (
+	PrefixReport,
+	error,
+) {
+	return cl.CopyPrefixContext(
+		context.Background(), srcBucket, srcPrefix, dstBucket, dstPrefix, opts)
+}
+
+// CopyPrefixContext is like CopyPrefix. Once ctx is done, it stops like
+// DeletePrefixContext.
+func (cl *Client) CopyPrefixContext(
+	ctx context.Context,
+	srcBucket,
+	srcPrefix,
+	dstBucket,
+	dstPrefix string,
+	opts PrefixOptions,
+) (
+	PrefixReport,
+	error,
+) {
+	overlap := prefixesOverlap(srcBucket, srcPrefix, dstBucket, dstPrefix)
+	return cl.eachPrefix(ctx, srcBucket, srcPrefix, overlap, 1, opts, func(rPaths []string) error {
+		dstPath := dstPrefix + strings.TrimPrefix(rPaths[0], srcPrefix)
+		return cl.CopyObject(ctx, srcBucket, rPaths[0], dstBucket, dstPath, CopyOptions{})
+	})
+}
+
+// MovePrefix is like CopyPrefix, but deletes each object after copying it.
+func (cl *Client) MovePrefix(
+	srcBucket,
+	srcPrefix,
+	dstBucket,
+	dstPrefix string,
+	opts PrefixOptions,
+) (
+	PrefixReport,
+	error,
+) {
+	return cl.MovePrefixContext(
+		context.Background(), srcBucket, srcPrefix, dstBucket, dstPrefix, opts)
+}
+
+// MovePrefixContext is like MovePrefix. Once ctx is done, it stops like
+// DeletePrefixContext.
+func (cl *Client) MovePrefixContext(
+	ctx context.Context,
+	srcBucket,
+	srcPrefix,
+	dstBucket,
+	dstPrefix string,
+	opts PrefixOptions,
+) (
+	PrefixReport,
+	error,
+) {
+	overlap := prefixesOverlap(srcBucket, srcPrefix, dstBucket, dstPrefix)
+	return cl.eachPrefix(ctx, srcBucket, srcPrefix, overlap, 1, opts, func(rPaths []string) error {
+		dstPath := dstPrefix + strings.TrimPrefix(rPaths[0], srcPrefix)
+		return cl.move(ctx, srcBucket, rPaths[0], dstBucket, dstPath)
+	})
+}
+
+// Move renames the object bucket/srcPath to bucket/dstPath by copying and
+// then deleting it. Use MovePrefix to move all objects below a prefix.
+func (cl *Client) Move(bucket, srcPath, dstPath string) error {
+	return cl.MoveContext(context.Background(), bucket, srcPath, dstPath)
+}
+
+func (cl *Client) MoveContext(
+	ctx context.Context,
+	bucket,
+	srcPath,
+	dstPath string,
+) error {
+	return cl.move(ctx, bucket, srcPath, bucket, dstPath)
+}
+
+// ----------------------------------------------------------------------------
+
+func (cl *Client) move(
+	ctx context.Context,
+	srcBucket,
+	srcPath,
+	dstBucket,
+	dstPath string,
+) error {
+	if srcBucket == dstBucket && srcPath == dstPath {
+		return nil
+	}
+	if err := cl.CopyObject(ctx, srcBucket, srcPath, dstBucket, dstPath, CopyOptions{}); err != nil {
+		return err
+	}
+	return cl.deleteObject(ctx, srcBucket, srcPath)
+}
+
+// deleteObject deletes a single object, returning its error rather than a
+// MultiError.
+func (cl *Client) deleteObject(ctx context.Context, bucket, rPath string) error {
+	err := cl.DeleteContext(ctx, bucket, rPath)
+	var multiErr *MultiError
+	if errors.As(err, &multiErr) {
+		return multiErr.Errs[rPath]
+	}
+	return err
+}
+
+// prefixesOverlap returns true if objects written below dstPrefix could be
+// listed below srcPrefix, or the other way around.
+func prefixesOverlap(srcBucket, srcPrefix, dstBucket, dstPrefix string) bool {
+	return srcBucket == dstBucket &&
+		(strings.HasPrefix(srcPrefix, dstPrefix) || strings.HasPrefix(dstPrefix, srcPrefix))
+}
+
+// eachPrefix calls fn for batches of up to batchSize objects in bucket below
+// prefix using opts.Workers workers, unless opts.DryRun is set, and reports
+// the outcome. If fn returns a *MultiError, only the objects it lists failed.
+// Objects are processed while they are listed, unless collect is set, in which
+// case they are all listed first. That's needed if fn writes objects that
+// could be listed, too.
+func (cl *Client) eachPrefix(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	collect bool,
+	batchSize int,
+	opts PrefixOptions,
+	fn func(rPaths []string) error,
+) (
+	PrefixReport,
+	error,
+) {
+	report := PrefixReport{
+		Failed: map[string]error{},
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 8
+	}
+
+	var l []FileInfo
+	if collect {
+		var err error
+		if l, err = cl.ListContext(ctx, bucket, prefix, true); err != nil {
+			return report, err
+		}
+	}
+
+	var (
+		batchCh = make(chan []string, workers)
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rPaths := range batchCh {
+				var err error
+				if !opts.DryRun {
+					err = fn(rPaths)
+				}
+
+				// Other errors apply to the whole batch.
+				var multiErr *MultiError
+				isMulti := errors.As(err, &multiErr)
+
+				mu.Lock()
+				for _, rPath := range rPaths {
+					rErr := err
+					if isMulti {
+						rErr = multiErr.Errs[rPath]
+					}
+					if rErr != nil {
+						report.Failed[rPath] = rErr
+					} else {
+						report.Done = append(report.Done, rPath)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var batch []string
+	add := func(rPath string) {
+		if batch = append(batch, rPath); len(batch) == batchSize {
+			batchCh <- batch
+			batch = nil
+		}
+	}
+
+	var err error
+	if collect {
+		for _, info := range l {
+			add(info.Name)
+		}
+	} else {
+		it := cl.NewLister(ctx, bucket, prefix, ListOptions{Recursive: true})
+		for it.Next() {
+			add(it.Info().Name)
+		}
+		err = it.Err()
+	}
+	if len(batch) > 0 {
+		batchCh <- batch
+	}
+	close(batchCh)
+
+	wg.Wait()
+
+	sort.Strings(report.Done)
+
+	return report, err
+}
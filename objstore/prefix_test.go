@@ -0,0 +1,168 @@
+package objstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientPrefixOps(t *testing.T) {
+	cl := NewClientForTesting()
+
+	put := func(rPaths ...string) {
+		for _, rPath := range rPaths {
+			if err := cl.PutBytes([]byte(rPath), testBucket, rPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	names := func(bucket, prefix string) []string {
+		l, err := cl.ListNames(bucket, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	listMatch := func(bucket, prefix string, expected ...string) {
+		l, err := cl.List(bucket, prefix, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l) != len(expected) {
+			t.Fatal(l, expected)
+		}
+		for i := range l {
+			if l[i].Name != expected[i] {
+				t.Fatal(l, expected)
+			}
+		}
+	}
+
+	put("a/1", "a/2", "a/b/3", "ab")
+
+	// Dry runs only report.
+	report, err := cl.DeletePrefix(testBucket, "a/", PrefixOptions{DryRun: true})
+	if err != nil || len(report.Failed) != 0 {
+		t.Fatal(report, err)
+	}
+	if len(report.Done) != 3 || report.Done[2] != "a/b/3" {
+		t.Fatal(report)
+	}
+	listMatch(testBucket, "", "a/1", "a/2", "a/b/3", "ab")
+
+	// Copy below the source prefix.
+	report, err = cl.CopyPrefix(testBucket, "a/", testBucket, "a/c/", PrefixOptions{Workers: 2})
+	if err != nil || len(report.Failed) != 0 || len(report.Done) != 3 {
+		t.Fatal(report, err)
+	}
+	listMatch(testBucket, "a/c/", "a/c/1", "a/c/2", "a/c/b/3")
+
+	r, err := cl.Get(testBucket, "a/c/b/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, err := ioutil.ReadAll(r); err != nil || string(data) != "a/b/3" {
+		t.Fatal(string(data), err)
+	}
+
+	// Move a prefix, and a single object.
+	report, err = cl.MovePrefix(testBucket, "a/c/", testBucket, "d/", PrefixOptions{})
+	if err != nil || len(report.Failed) != 0 || len(report.Done) != 3 {
+		t.Fatal(report, err)
+	}
+	listMatch(testBucket, "", "a/1", "a/2", "a/b/3", "ab", "d/1", "d/2", "d/b/3")
+
+	if err := cl.Move(testBucket, "ab", "e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Move(testBucket, "e", "e"); err != nil {
+		t.Fatal(err)
+	}
+	if l := names(testBucket, "e"); len(l) != 1 {
+		t.Fatal(l)
+	}
+
+	// Delete.
+	report, err = cl.DeletePrefix(testBucket, "a", PrefixOptions{Workers: 1})
+	if err != nil || len(report.Failed) != 0 || len(report.Done) != 3 {
+		t.Fatal(report, err)
+	}
+	listMatch(testBucket, "", "d/1", "d/2", "d/b/3", "e")
+
+	// Failures are reported per object.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = cl.CopyPrefixContext(ctx, testBucket, "d/", testBucket, "d/", PrefixOptions{})
+	if err != context.Canceled || len(report.Done) != 0 {
+		t.Fatal(report, err)
+	}
+}
+
+func TestClientCopyPrefixBuckets(t *testing.T) {
+	cl := NewClientForTesting()
+	if cl.Host != "" {
+		t.Skip("Needs a second bucket.")
+	}
+
+	dstBucket := testBucket + "-dst"
+	dstPath := filepath.Join(testLocalRoot, dstBucket)
+	if err := os.RemoveAll(dstPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dstPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstPath)
+
+	if err := cl.PutBytes([]byte("x"), testBucket, "a/x"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := cl.CopyPrefix(testBucket, "a/", dstBucket, "b/", PrefixOptions{})
+	if err != nil || len(report.Failed) != 0 || len(report.Done) != 1 {
+		t.Fatal(report, err)
+	}
+
+	r, err := cl.Get(dstBucket, "b/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, err := ioutil.ReadAll(r); err != nil || string(data) != "x" {
+		t.Fatal(string(data), err)
+	}
+
+	report, err = cl.CopyPrefix(testBucket, "a/", "not-a-bucket", "b/", PrefixOptions{})
+	if err != nil || len(report.Failed) != 1 || report.Failed["a/x"] != ErrBucketNotFound {
+		t.Fatal(report, err)
+	}
+}
+
+func TestClientDeletePrefixBatches(t *testing.T) {
+	cl, be := newFlakyClientForTesting(0, ErrBucketNotFound)
+
+	for _, rPath := range []string{"del/1", "del/2", "del/3"} {
+		if err := cl.PutBytes([]byte(rPath), testBucket, rPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	be.Fails, be.Calls = 1, 0
+
+	// All objects are deleted in one request, and only the first one fails.
+	report, err := cl.DeletePrefix(testBucket, "del/", PrefixOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(be.Deletes) != 1 || len(be.Deletes[0]) != 3 {
+		t.Fatal(be.Deletes)
+	}
+	if len(report.Failed) != 1 || report.Failed["del/1"] != ErrBucketNotFound ||
+		len(report.Done) != 2 || report.Done[0] != "del/2" {
+		t.Fatal(report)
+	}
+}
@@ -0,0 +1,432 @@
+package objstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// GetRange returns length bytes of the data of bucket/rPath starting at
+// offset, or the rest of the data if length is negative. The range is
+// shortened if it extends beyond the end. Only the part of the object holding
+// the range is read, also for encrypted objects.
+//
+// Compressed objects can't be read in ranges, and give ErrNotSeekable. The
+// checksum isn't verified, but encrypted data is authenticated. Objects
+// written by older versions of this package are taken to be encrypted.
+func (cl *Client) GetRange(
+	bucket,
+	rPath string,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	return cl.GetRangeContext(context.Background(), bucket, rPath, offset, length)
+}
+
+func (cl *Client) GetRangeContext(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	if offset < 0 {
+		return nil, errInvalidOffset
+	}
+
+	var r io.ReadCloser
+	err := cl.withRetry(ctx, func() error {
+		rr, err := cl.openRanges(ctx, bucket, rPath)
+		if err != nil {
+			return err
+		}
+		r, err = rr.open(ctx, offset, length)
+		return err
+	})
+	return r, err
+}
+
+// Open returns the object bucket/rPath for reading at any offset. Like
+// GetRange, only the parts of the object that are read are fetched, and the
+// object must not be compressed.
+func (cl *Client) Open(bucket, rPath string) (*Object, error) {
+	return cl.OpenContext(context.Background(), bucket, rPath)
+}
+
+// OpenContext is like Open. Reading from the returned object fails once ctx
+// is done.
+func (cl *Client) OpenContext(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	*Object,
+	error,
+) {
+	var rr *rangeReader
+	err := cl.withRetry(ctx, func() (err error) {
+		rr, err = cl.openRanges(ctx, bucket, rPath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Object{ctx: ctx, rr: rr}, nil
+}
+
+// openRanges returns a rangeReader for the uncompressed object bucket/rPath.
+func (cl *Client) openRanges(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) (
+	*rangeReader,
+	error,
+) {
+	info, err := cl.Backend.Stat(ctx, bucket, rPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, codec := objectEncoding(info, GetOptions{}); codec != "" {
+		log.Printf("Can't read ranges of %s/%s compressed with %s", bucket, rPath, codec)
+		return nil, ErrNotSeekable
+	}
+
+	return cl.newRangeReader(ctx, bucket, rPath, info, GetOptions{})
+}
+
+// ----------------------------------------------------------------------------
+
+// Object is an object opened with Open. It's an io.ReaderAt, an io.Reader and
+// an io.Seeker. ReadAt reads the requested range only, and may be called
+// concurrently. Read reads sequentially from the offset set by Seek, and
+// mustn't be called concurrently with Read or Seek.
+type Object struct {
+	ctx    context.Context
+	rr     *rangeReader
+	offset int64         // For Read and Seek.
+	r      io.ReadCloser // Reads from offset, if open.
+}
+
+// Size returns the size of the object's data.
+func (o *Object) Size() int64 {
+	return o.rr.size
+}
+
+func (o *Object) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errInvalidOffset
+	}
+	if off >= o.rr.size && len(p) > 0 {
+		return 0, io.EOF
+	}
+
+	buf := p
+	if rest := o.rr.size - off; int64(len(buf)) > rest {
+		buf = buf[:rest]
+	}
+
+	var n int
+	err := o.rr.cl.withRetry(o.ctx, func() error {
+		r, err := o.rr.open(o.ctx, off, int64(len(buf)))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		n, err = io.ReadFull(r, buf)
+		return err
+	})
+
+	// Like other io.ReaderAt, fail with io.EOF if the range extends beyond
+	// the end.
+	if err == nil && len(buf) < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (o *Object) Read(p []byte) (int, error) {
+	if o.r == nil {
+		r, err := o.rr.open(o.ctx, o.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		o.r = r
+	}
+
+	n, err := o.r.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.rr.size
+	}
+	if offset < 0 {
+		return 0, errInvalidOffset
+	}
+
+	if offset != o.offset && o.r != nil {
+		o.r.Close()
+		o.r = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+// Close closes the reader used by Read, if any.
+func (o *Object) Close() error {
+	if o.r == nil {
+		return nil
+	}
+	err := o.r.Close()
+	o.r = nil
+	return err
+}
+
+// ----------------------------------------------------------------------------
+
+var errInvalidOffset = errors.New("InvalidOffset")
+
+// rangeReader reads ranges of the data of an object as stored, but
+// decrypted, i.e. before decompression. Only the parts of the object holding
+// a range are read.
+type rangeReader struct {
+	cl     *Client
+	bucket string
+	rPath  string
+	info   FileInfo
+
+	chunked bool   // Encrypted in the chunked format.
+	iv      []byte // For the legacy encryption format.
+	chunks  int64  // Number of chunks in the chunked format.
+	size    int64  // Of the decrypted data.
+}
+
+// newRangeReader returns a rangeReader for the object bucket/rPath with the
+// given information. For encrypted objects, the encryption header is read.
+func (cl *Client) newRangeReader(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	info FileInfo,
+	opts GetOptions,
+) (
+	*rangeReader,
+	error,
+) {
+	rr := &rangeReader{
+		cl:     cl,
+		bucket: bucket,
+		rPath:  rPath,
+		info:   info,
+		size:   info.Size,
+	}
+
+	if encryption, _ := objectEncoding(info, opts); encryption == EncryptionNone {
+		return rr, nil
+	}
+
+	// Enough for the header or the legacy IV.
+	hr, err := cl.Backend.GetRange(ctx, bucket, rPath, 0, min64(aes.BlockSize, info.Size))
+	if err != nil {
+		return nil, err
+	}
+	defer hr.Close()
+
+	br := bufio.NewReader(hr)
+	if rr.chunked, err = peekEncHeader(br); err != nil {
+		return nil, convertError(err)
+	}
+
+	if rr.chunked {
+		rr.chunks, rr.size = encLayout(info.Size)
+		return rr, nil
+	}
+
+	rr.iv = make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(br, rr.iv); err != nil {
+		log.Printf("Failed to read IV: %v", err)
+		return nil, err
+	}
+	rr.size = info.Size - int64(len(rr.iv))
+	return rr, nil
+}
+
+// open returns length bytes of the data starting at offset, or the rest of
+// the data if length is negative. Ranges beyond the end are shortened.
+func (rr *rangeReader) open(
+	ctx context.Context,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	if offset < 0 {
+		return nil, errInvalidOffset
+	}
+	if offset > rr.size {
+		offset = rr.size
+	}
+	if length < 0 || offset+length > rr.size {
+		length = rr.size - offset
+	}
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	switch {
+	case rr.chunked:
+		return rr.openChunks(ctx, offset, length)
+	case rr.iv != nil:
+		return rr.openCTR(ctx, offset, length)
+	default:
+		return rr.cl.Backend.GetRange(ctx, rr.bucket, rr.rPath, offset, length)
+	}
+}
+
+func (rr *rangeReader) openChunks(
+	ctx context.Context,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	const chunkSize = encSegmentSize + 16
+
+	first := offset / encSegmentSize
+	last := (offset + length - 1) / encSegmentSize
+	start := encHeaderSize + first*chunkSize
+	end := min64(encHeaderSize+(last+1)*chunkSize, rr.info.Size)
+
+	obj, err := rr.cl.Backend.GetRange(ctx, rr.bucket, rr.rPath, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decryptChunksReader(rr.cl.keyring(), rr.info.Meta, obj, first, rr.chunks-1)
+	if err != nil {
+		obj.Close()
+		return nil, convertError(err)
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r, offset-first*encSegmentSize); err != nil {
+		obj.Close()
+		log.Printf("Failed to skip to offset %d: %v", offset, err)
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: &exactReader{r: r, n: length},
+		Closer: obj,
+	}, nil
+}
+
+func (rr *rangeReader) openCTR(
+	ctx context.Context,
+	offset,
+	length int64,
+) (
+	io.ReadCloser,
+	error,
+) {
+	encKey, err := rr.cl.keyring().legacyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ivSize := int64(len(rr.iv))
+	obj, err := rr.cl.Backend.GetRange(ctx, rr.bucket, rr.rPath, ivSize+offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decryptReaderCTRAt(encKey, rr.iv, obj, offset)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: &exactReader{r: r, n: length},
+		Closer: obj,
+	}, nil
+}
+
+// getStoredRange returns a range of the data of bucket/rPath as stored, see
+// rangeReader.
+func (cl *Client) getStoredRange(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	info FileInfo,
+	offset,
+	length int64,
+	opts GetOptions,
+) (
+	io.ReadCloser,
+	error,
+) {
+	rr, err := cl.newRangeReader(ctx, bucket, rPath, info, opts)
+	if err != nil {
+		return nil, err
+	}
+	return rr.open(ctx, offset, length)
+}
+
+// ----------------------------------------------------------------------------
+
+// exactReader reads n bytes from r, failing with io.ErrUnexpectedEOF if r
+// ends early.
+type exactReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+	if err == io.EOF && r.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
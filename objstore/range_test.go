@@ -0,0 +1,191 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestClientStoredRange(t *testing.T) {
+	cl := NewClientForTesting()
+	ctx := context.Background()
+
+	data := make([]byte, 3*encSegmentSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	if err := cl.PutBytes(data, testBucket, "enc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutNC(bytes.NewReader(data), testBucket, "plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	type TestCase struct {
+		Offset int64
+		Length int64
+	}
+
+	size := int64(len(data))
+	cases := []TestCase{
+		{0, -1},
+		{0, 0},
+		{0, 10},
+		{5, encSegmentSize},
+		{encSegmentSize - 1, 2},
+		{encSegmentSize, encSegmentSize},
+		{2*encSegmentSize + 7, -1},
+		{size - 1, 1},
+		{size - 10, 100},
+		{size, -1},
+		{size + 10, 5},
+	}
+
+	for _, rPath := range []string{"enc", "plain"} {
+		info, err := cl.Backend.Stat(ctx, testBucket, rPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range cases {
+			r, err := cl.getStoredRange(ctx, testBucket, rPath, info, tc.Offset, tc.Length, GetOptions{})
+			if err != nil {
+				t.Fatal(rPath, tc, err)
+			}
+			buf, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(rPath, tc, err)
+			}
+
+			start := tc.Offset
+			if start > size {
+				start = size
+			}
+			end := start + tc.Length
+			if tc.Length < 0 || end > size {
+				end = size
+			}
+			if !bytes.Equal(buf, data[start:end]) {
+				t.Fatal(rPath, tc, len(buf))
+			}
+		}
+	}
+}
+
+func TestClientGetRange(t *testing.T) {
+	cl := NewClientForTesting()
+
+	data := make([]byte, 2*encSegmentSize+10)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	if err := cl.PutBytes(data, testBucket, "enc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutNC(bytes.NewReader(data), testBucket, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutGZ(bytes.NewReader(data), testBucket, "gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rPath := range []string{"enc", "plain"} {
+		r, err := cl.GetRange(testBucket, rPath, encSegmentSize-3, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(buf, data[encSegmentSize-3:encSegmentSize+3]) {
+			t.Fatal(rPath, err)
+		}
+	}
+
+	for _, rPath := range []string{"enc", "plain"} {
+		if _, err := cl.GetRange(testBucket, rPath, -5, 10); err != errInvalidOffset {
+			t.Fatal(rPath, err)
+		}
+	}
+
+	if _, err := cl.GetRange(testBucket, "gz", 0, 10); err != ErrNotSeekable {
+		t.Fatal(err)
+	}
+	if _, err := cl.Open(testBucket, "gz"); err != ErrNotSeekable {
+		t.Fatal(err)
+	}
+	if _, err := cl.GetRange(testBucket, "missing", 0, 10); err != ErrPathNotFound {
+		t.Fatal(err)
+	}
+}
+
+func TestClientOpen(t *testing.T) {
+	cl := NewClientForTesting()
+
+	data := make([]byte, 2*encSegmentSize+10)
+	rand.New(rand.NewSource(3)).Read(data)
+
+	if err := cl.PutBytes(data, testBucket, "enc"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := cl.Open(testBucket, "enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+
+	if obj.Size() != int64(len(data)) {
+		t.Fatal(obj.Size())
+	}
+
+	// ReadAt.
+	buf := make([]byte, 100)
+	for _, off := range []int64{0, encSegmentSize - 50, 2*encSegmentSize - 90} {
+		n, err := obj.ReadAt(buf, off)
+		if err != nil || !bytes.Equal(buf[:n], data[off:off+100]) {
+			t.Fatal(off, n, err)
+		}
+	}
+
+	n, err := obj.ReadAt(buf, int64(len(data))-10)
+	if n != 10 || err != io.EOF || !bytes.Equal(buf[:n], data[len(data)-10:]) {
+		t.Fatal(n, err)
+	}
+	if _, err := obj.ReadAt(buf, int64(len(data))); err != io.EOF {
+		t.Fatal(err)
+	}
+
+	sr := io.NewSectionReader(obj, 10, int64(len(data)))
+	all, err := ioutil.ReadAll(sr)
+	if err != nil || !bytes.Equal(all, data[10:]) {
+		t.Fatal(err)
+	}
+
+	// Seek and Read.
+	if _, err := obj.Seek(-20, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	all, err = ioutil.ReadAll(obj)
+	if err != nil || !bytes.Equal(all, data[len(data)-20:]) {
+		t.Fatal(err)
+	}
+
+	if _, err := obj.Seek(encSegmentSize, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(obj, buf); err != nil || !bytes.Equal(buf, data[encSegmentSize:encSegmentSize+100]) {
+		t.Fatal(err)
+	}
+	if pos, err := obj.Seek(-50, io.SeekCurrent); err != nil || pos != encSegmentSize+50 {
+		t.Fatal(pos, err)
+	}
+	if _, err := io.ReadFull(obj, buf); err != nil || !bytes.Equal(buf, data[encSegmentSize+50:encSegmentSize+150]) {
+		t.Fatal(err)
+	}
+
+	if _, err := obj.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,282 @@
+package objstore
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"sort"
+	"sync"
+)
+
+// ReencryptOptions configures ReencryptPrefix.
+type ReencryptOptions struct {
+	Workers int  // Number of objects processed concurrently. Default: 8.
+	DryRun  bool // Report what would be done without writing anything.
+
+	// Objects in the legacy format can't be told apart from plaintext
+	// objects, e.g. written by PutNC, unless the object's encryption was
+	// recorded when it was written. Classify is called for such objects,
+	// and returns EncryptionNone or EncryptionLegacy if it's known how the
+	// object was written. Otherwise, or if Classify is nil, the object fails
+	// with ErrAmbiguousEncryption and is left alone.
+	Classify func(info FileInfo) string
+}
+
+// ReencryptReport lists the objects processed by ReencryptPrefix by outcome.
+// In a dry run, it lists what would have been done.
+type ReencryptReport struct {
+	Current     []string         // Already current, and left alone.
+	Rewrapped   []string         // Data key re-wrapped with the primary key.
+	Reencrypted []string         // Decrypted and encrypted again.
+	Encrypted   []string         // Plaintext objects that were encrypted.
+	Failed      map[string]error // Objects that couldn't be processed.
+}
+
+// Err returns a *MultiError for the failed objects, or nil if none failed.
+func (r ReencryptReport) Err() error {
+	return newMultiError(r.Failed)
+}
+
+type reencryptAction int
+
+const (
+	reencryptCurrent reencryptAction = iota
+	reencryptRewrap
+	reencryptReencrypt
+	reencryptEncrypt
+)
+
+// ----------------------------------------------------------------------------
+
+// Reencrypt rewrites the object bucket/rPath so that it's encrypted with the
+// primary key in the current format. Objects that may be plaintext or
+// encrypted in the legacy format fail with ErrAmbiguousEncryption, see
+// ReencryptOptions.Classify. For objects already in the current
+// format only the data key in the metadata is re-wrapped, leaving the data
+// alone, while objects in the legacy format are downloaded, decrypted and
+// uploaded encrypted again. Objects that are already encrypted with the
+// primary key in the current format are left alone.
+func (cl *Client) Reencrypt(bucket, rPath string) error {
+	return cl.ReencryptContext(context.Background(), bucket, rPath)
+}
+
+func (cl *Client) ReencryptContext(
+	ctx context.Context,
+	bucket,
+	rPath string,
+) error {
+	return cl.withRetry(ctx, func() error {
+		_, err := cl.reencrypt(ctx, bucket, rPath, ReencryptOptions{})
+		return err
+	})
+}
+
+// ReencryptPrefix re-encrypts all objects in bucket below prefix like
+// Reencrypt, skipping objects that are already encrypted with the primary key
+// in the current format. An interrupted run can therefore be resumed by
+// running it again.
+//
+// The returned error is only set if the objects couldn't be listed. Errors for
+// individual objects are returned in the report.
+func (cl *Client) ReencryptPrefix(
+	bucket,
+	prefix string,
+	opts ReencryptOptions,
+) (
+	ReencryptReport,
+	error,
+) {
+	return cl.ReencryptPrefixContext(context.Background(), bucket, prefix, opts)
+}
+
+// ReencryptPrefixContext is like ReencryptPrefix. Once ctx is done, the
+// remaining objects are reported as failed with ctx's error.
+func (cl *Client) ReencryptPrefixContext(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	opts ReencryptOptions,
+) (
+	ReencryptReport,
+	error,
+) {
+	report := ReencryptReport{
+		Failed: map[string]error{},
+	}
+
+	l, err := cl.ListContext(ctx, bucket, prefix, true)
+	if err != nil {
+		return report, err
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 8
+	}
+
+	rPathCh := make(chan string, len(l))
+	for _, info := range l {
+		rPathCh <- info.Name
+	}
+	close(rPathCh)
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rPath := range rPathCh {
+				var action reencryptAction
+				err := cl.withRetry(ctx, func() (err error) {
+					action, err = cl.reencrypt(ctx, bucket, rPath, opts)
+					return err
+				})
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					report.Failed[rPath] = err
+				case action == reencryptCurrent:
+					report.Current = append(report.Current, rPath)
+				case action == reencryptRewrap:
+					report.Rewrapped = append(report.Rewrapped, rPath)
+				case action == reencryptReencrypt:
+					report.Reencrypted = append(report.Reencrypted, rPath)
+				case action == reencryptEncrypt:
+					report.Encrypted = append(report.Encrypted, rPath)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	sort.Strings(report.Current)
+	sort.Strings(report.Rewrapped)
+	sort.Strings(report.Reencrypted)
+	sort.Strings(report.Encrypted)
+
+	return report, nil
+}
+
+func (cl *Client) reencrypt(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	opts ReencryptOptions,
+) (
+	reencryptAction,
+	error,
+) {
+	keys := cl.keyring()
+
+	info, err := cl.Backend.Stat(ctx, bucket, rPath)
+	if err != nil {
+		return 0, err
+	}
+
+	// Objects in the current format only need their data key re-wrapped,
+	// which is stored in the metadata.
+	if _, ok := info.Meta[metaEncDataKey]; ok {
+		if info.Meta[metaEncKeyID] == keys.Primary {
+			// Also for skipped objects, the key must be known.
+			if _, err := unwrapDataKey(keys, info.Meta); err != nil {
+				return 0, convertError(err)
+			}
+			return reencryptCurrent, nil
+		}
+
+		meta, err := rewrapMeta(keys, info.Meta)
+		if err != nil {
+			return 0, convertError(err)
+		}
+		if opts.DryRun {
+			return reencryptRewrap, nil
+		}
+		if err := cl.Backend.SetMeta(ctx, bucket, rPath, meta); err != nil {
+			log.Printf("Failed to re-wrap the data key of %s/%s: %v", bucket, rPath, err)
+			return 0, err
+		}
+		return reencryptRewrap, nil
+	}
+
+	obj, info, err := cl.Backend.Get(ctx, bucket, rPath)
+	if err != nil {
+		return 0, err
+	}
+	defer obj.Close()
+
+	src := bufio.NewReader(obj)
+	encryption := info.Meta[metaEncryption]
+
+	var chunked bool
+	if encryption != EncryptionNone {
+		if chunked, err = peekEncHeader(src); err != nil {
+			return 0, convertError(err)
+		}
+	}
+
+	if !chunked && encryption == "" && opts.Classify != nil {
+		switch classified := opts.Classify(info); classified {
+		case EncryptionNone, EncryptionLegacy:
+			encryption = classified
+		}
+	}
+
+	var (
+		action  reencryptAction
+		r       io.Reader
+		encMeta map[string]string
+	)
+	switch {
+	case chunked:
+		// The data key is missing from the metadata.
+		log.Printf("Missing data key for %s/%s", bucket, rPath)
+		return 0, ErrDecryptFailed
+	case encryption == EncryptionNone:
+		action = reencryptEncrypt
+		r, encMeta, err = encryptReader(keys, src)
+	case encryption == EncryptionAESGCM:
+		// Objects recorded as encrypted are always in the chunked format.
+		log.Printf("Missing encryption header in %s/%s", bucket, rPath)
+		return 0, ErrDecryptFailed
+	case encryption != EncryptionLegacy:
+		log.Printf("Can't tell if %s/%s is plaintext or legacy encrypted", bucket, rPath)
+		return 0, ErrAmbiguousEncryption
+	default:
+		action = reencryptReencrypt
+		if r, err = decryptReader(keys, info.Meta, src); err == nil {
+			r, encMeta, err = encryptReader(keys, r)
+		}
+	}
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	if opts.DryRun {
+		return action, nil
+	}
+
+	// The data is unchanged, so the metadata, including the checksum, is
+	// kept.
+	meta := map[string]string{}
+	for key, val := range info.Meta {
+		meta[key] = val
+	}
+	for key, val := range encMeta {
+		meta[key] = val
+	}
+	meta[metaEncryption] = EncryptionAESGCM
+
+	if err := cl.Backend.Put(ctx, bucket, rPath, r, BackendPutOptions{Meta: meta}); err != nil {
+		log.Printf("Failed to re-encrypt %s/%s: %v", bucket, rPath, err)
+		return 0, err
+	}
+	return action, nil
+}
@@ -0,0 +1,147 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"io/ioutil"
+	"testing"
+)
+
+func TestClientReencryptPrefix(t *testing.T) {
+	cl := NewClientForTesting()
+
+	if err := cl.PutNC(bytes.NewBufferString("plain"), testBucket, "r/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutBytes([]byte("old"), testBucket, "r/old"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Objects without an encryption header or metadata, which are plaintext
+	// and in the legacy format.
+	ctx := context.Background()
+	unknown := bytes.NewBufferString("unknown")
+	if err := cl.Backend.Put(ctx, testBucket, "r/unknown", unknown, BackendPutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The legacy key defaults to the primary key.
+	newKey := []byte("abcdefghijklmnopqrstuvwxyz012345")
+	block, err := aes.NewCipher(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, block.BlockSize())
+	enc := make([]byte, len("legacy"))
+	cipher.NewCTR(block, iv).XORKeyStream(enc, []byte("legacy"))
+	legacy := bytes.NewReader(append(iv, enc...))
+	if err := cl.Backend.Put(ctx, testBucket, "r/legacy", legacy, BackendPutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cl.Keys = &Keyring{
+		Primary: "new",
+		Keys:    map[string][]byte{"": cl.EncKey, "new": newKey},
+	}
+
+	if err := cl.PutBytes([]byte("new"), testBucket, "r/new"); err != nil {
+		t.Fatal(err)
+	}
+
+	oldBody := getStoredForTesting(t, cl, "r/old")
+
+	// The plaintext object is recognized by its metadata, while the objects
+	// without metadata are left alone.
+	opts := ReencryptOptions{}
+
+	report, err := cl.ReencryptPrefix(testBucket, "r/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed) != 2 ||
+		report.Failed["r/unknown"] != ErrAmbiguousEncryption ||
+		report.Failed["r/legacy"] != ErrAmbiguousEncryption ||
+		len(report.Current) != 1 ||
+		len(report.Encrypted) != 1 || report.Encrypted[0] != "r/plain" ||
+		len(report.Rewrapped) != 1 || report.Rewrapped[0] != "r/old" {
+		t.Fatal(report)
+	}
+
+	opts = ReencryptOptions{
+		DryRun: true,
+		Classify: func(info FileInfo) string {
+			switch info.Name {
+			case "r/unknown":
+				return EncryptionNone
+			case "r/legacy":
+				return EncryptionLegacy
+			}
+			return ""
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		report, err := cl.ReencryptPrefix(testBucket, "r/", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Failed) != 0 ||
+			len(report.Current) != 3 ||
+			len(report.Encrypted) != 1 || report.Encrypted[0] != "r/unknown" ||
+			len(report.Reencrypted) != 1 || report.Reencrypted[0] != "r/legacy" {
+			t.Fatal(report)
+		}
+		opts.DryRun = false
+	}
+
+	// A second run finds nothing to do.
+	report, err = cl.ReencryptPrefix(testBucket, "r/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Current) != 5 {
+		t.Fatal(report)
+	}
+
+	// Re-wrapping only replaced the metadata.
+	if !bytes.Equal(oldBody, getStoredForTesting(t, cl, "r/old")) {
+		t.Fatal("data changed")
+	}
+
+	cl.Keys = &Keyring{
+		Primary: "new",
+		Keys:    map[string][]byte{"new": newKey},
+	}
+
+	for _, name := range []string{"plain", "old", "new", "unknown", "legacy"} {
+		r, err := cl.Get(testBucket, "r/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != name {
+			t.Fatal(name, string(buf))
+		}
+	}
+}
+
+// getStoredForTesting returns the data of bucket/rPath as stored.
+func getStoredForTesting(t *testing.T, cl *Client, rPath string) []byte {
+	obj, _, err := cl.Backend.Get(context.Background(), testBucket, rPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+
+	buf, err := ioutil.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
@@ -0,0 +1,254 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how a Client retries failed operations. Delays grow
+// exponentially from BaseDelay up to MaxDelay, and each delay is shortened by
+// a random fraction of up to Jitter.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts per operation, including the first.
+	BaseDelay   time.Duration // Delay before the first retry.
+	MaxDelay    time.Duration // Upper bound for the delay between attempts.
+	MaxElapsed  time.Duration // Give up once this much time has passed. 0: no limit.
+	Jitter      float64       // Fraction of each delay that is random, 0 to 1.
+
+	// Retryable reports whether an operation failing with the given error
+	// should be retried. Default: IsTransient.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy is used by clients without a RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    32 * time.Second,
+	MaxElapsed:  2 * time.Minute,
+	Jitter:      0.5,
+}
+
+// IsTransient reports whether err is likely to go away when retrying: server
+// errors, throttling, timeouts and dropped connections. Errors such as
+// ErrPathNotFound, failures to decrypt or verify data, local file errors and
+// cancelled contexts are permanent. A MultiError is transient if the errors
+// of all objects are.
+func IsTransient(err error) bool {
+	var multiErr *MultiError
+	if errors.As(err, &multiErr) {
+		for _, err := range multiErr.Errs {
+			if !IsTransient(err) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case err == nil:
+		return false
+
+	case errors.As(err, new(readerError)),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrPathNotFound),
+		errors.Is(err, ErrBucketNotFound),
+		errors.Is(err, ErrDecryptFailed),
+		errors.Is(err, ErrAmbiguousEncryption),
+		errors.Is(err, ErrChecksumMismatch),
+		errors.Is(err, ErrUnsafeArchive),
+		errors.Is(err, ErrArchiveTooLarge),
+		errors.Is(err, ErrStaleIndex),
+		errors.Is(err, ErrNotSeekable),
+		errors.Is(err, ErrKeyNotFound):
+		return false
+
+	case isTransientMinioError(err):
+		return true
+
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return false
+}
+
+// ----------------------------------------------------------------------------
+
+// retryPolicy returns the policy to use: override if given, else the
+// client's.
+func (cl *Client) retryPolicy(override *RetryPolicy) RetryPolicy {
+	p := DefaultRetryPolicy
+	if override != nil {
+		p = *override
+	} else if cl.Retry != nil {
+		p = *cl.Retry
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsTransient
+	}
+	return p
+}
+
+// permanentError stops withRetry from retrying err, regardless of the policy.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// readerError is an error reading the data to upload from a reader given by
+// the caller. It's never retried, as it's not the backend's: for example,
+// io.ErrUnexpectedEOF is transient when reading from the backend, but not when
+// reading a truncated local file.
+type readerError struct {
+	err error
+}
+
+func (e readerError) Error() string {
+	return e.err.Error()
+}
+
+func (e readerError) Unwrap() error {
+	return e.err
+}
+
+// withRetry calls fn until it succeeds or the client's retry policy gives up.
+func (cl *Client) withRetry(ctx context.Context, fn func() error) error {
+	return cl.withRetryPolicy(ctx, nil, fn)
+}
+
+// withRetryPolicy is like withRetry, using the given policy instead of the
+// client's if it isn't nil.
+func (cl *Client) withRetryPolicy(
+	ctx context.Context,
+	policy *RetryPolicy,
+	fn func() error,
+) error {
+	p := cl.retryPolicy(policy)
+
+	start := time.Now()
+	delay := p.BaseDelay
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var rErr readerError
+		if errors.As(err, &rErr) {
+			return rErr.err
+		}
+		if pErr, ok := err.(permanentError); ok {
+			return pErr.err
+		}
+		if attempt >= p.MaxAttempts || !p.Retryable(err) {
+			return err
+		}
+
+		sleep := delay - time.Duration(p.Jitter*rand.Float64()*float64(delay))
+		if p.MaxElapsed > 0 && time.Since(start)+sleep > p.MaxElapsed {
+			return err
+		}
+
+		log.Printf("Retrying in %v after error: %v", sleep, err)
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if delay *= 2; delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// putWithRetry calls put with a reader returning the data read from r,
+// retrying as long as r can be rewound: if it's an io.Seeker, or if nothing
+// was read from it yet. The client's retry policy is used if policy is nil.
+func (cl *Client) putWithRetry(
+	ctx context.Context,
+	policy *RetryPolicy,
+	r io.Reader,
+	put func(io.Reader) error,
+) error {
+	seeker, _ := r.(io.Seeker)
+
+	var offset int64
+	if seeker != nil {
+		var err error
+		if offset, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+
+	cr := &countingReader{r: r}
+
+	return cl.withRetryPolicy(ctx, policy, func() error {
+		if cr.n > 0 {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return permanentError{err}
+			}
+			cr.n = 0
+		}
+
+		err := put(cr)
+		if err != nil && cr.n > 0 && seeker == nil {
+			return permanentError{err}
+		}
+		return err
+	})
+}
+
+// countingReader counts the bytes read from the caller's reader r, and marks
+// its errors as readerError.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		err = readerError{err}
+	}
+	return n, err
+}
+
+// readerAtErrors marks the errors of the caller's io.ReaderAt r as
+// readerError.
+type readerAtErrors struct {
+	r io.ReaderAt
+}
+
+func (r readerAtErrors) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		err = readerError{err}
+	}
+	return n, err
+}
@@ -0,0 +1,318 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyBackend fails the first Fails calls to Put and Stat with Err. Calls to
+// Delete fail for the first object only.
+type flakyBackend struct {
+	Backend
+	Fails   int
+	Err     error
+	Calls   int
+	Deletes [][]string // Objects passed to each call to Delete.
+}
+
+func (be *flakyBackend) fail() error {
+	be.Calls++
+	if be.Calls <= be.Fails {
+		return be.Err
+	}
+	return nil
+}
+
+func (be *flakyBackend) Put(
+	ctx context.Context,
+	bucket,
+	rPath string,
+	r io.Reader,
+	opts BackendPutOptions,
+) error {
+	if err := be.fail(); err != nil {
+		// Consume the data, as a failed upload would.
+		io.Copy(ioutil.Discard, r)
+		return err
+	}
+	return be.Backend.Put(ctx, bucket, rPath, r, opts)
+}
+
+func (be *flakyBackend) Stat(ctx context.Context, bucket, rPath string) (FileInfo, error) {
+	if err := be.fail(); err != nil {
+		return FileInfo{}, err
+	}
+	return be.Backend.Stat(ctx, bucket, rPath)
+}
+
+func (be *flakyBackend) Delete(ctx context.Context, bucket string, rPaths ...string) error {
+	be.Deletes = append(be.Deletes, rPaths)
+	if err := be.fail(); err != nil && len(rPaths) > 0 {
+		if dErr := be.Backend.Delete(ctx, bucket, rPaths[1:]...); dErr != nil {
+			return dErr
+		}
+		return &MultiError{Errs: map[string]error{rPaths[0]: err}}
+	}
+	return be.Backend.Delete(ctx, bucket, rPaths...)
+}
+
+func newFlakyClientForTesting(fails int, err error) (*Client, *flakyBackend) {
+	cl := NewClientForTesting()
+	be := &flakyBackend{Backend: cl.Backend, Fails: fails, Err: err}
+	cl.Backend = be
+	cl.Retry = &RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    4 * time.Millisecond,
+		Jitter:      0.5,
+	}
+	return cl, be
+}
+
+func TestRetryTransient(t *testing.T) {
+	cl, be := newFlakyClientForTesting(3, syscall.ECONNRESET)
+
+	// Seekable readers are rewound between attempts.
+	err := cl.Put(bytes.NewReader([]byte("Some data.")), testBucket, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if be.Calls != 4 {
+		t.Fatal(be.Calls)
+	}
+
+	r, err := cl.Get(testBucket, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "Some data." {
+		t.Fatal(string(buf))
+	}
+}
+
+func TestRetryTransientNoEncrypt(t *testing.T) {
+	cl, be := newFlakyClientForTesting(1, syscall.ECONNRESET)
+
+	// Files and other sized sections are read again from the start.
+	if err := cl.PutNC(bytes.NewReader([]byte("Some data.")), testBucket, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("files/out/retry", []byte("File data."), 0600); err != nil {
+		t.Fatal(err)
+	}
+	be.Calls = 0
+	opts := PutOptions{NoEncrypt: true}
+	if err := cl.PutObjectFile(context.Background(), "files/out/retry", testBucket, "b", opts); err != nil {
+		t.Fatal(err)
+	}
+	if be.Calls != 2 {
+		t.Fatal(be.Calls)
+	}
+
+	for rPath, data := range map[string]string{"a": "Some data.", "b": "File data."} {
+		r, err := cl.Get(testBucket, rPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != data {
+			t.Fatal(rPath, string(buf))
+		}
+	}
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	cl, be := newFlakyClientForTesting(4, syscall.ECONNRESET)
+
+	if _, err := cl.Stat(testBucket, "a"); err != syscall.ECONNRESET {
+		t.Fatal(err)
+	}
+	if be.Calls != 4 {
+		t.Fatal(be.Calls)
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	cl, be := newFlakyClientForTesting(1, ErrBucketNotFound)
+
+	if _, err := cl.Stat(testBucket, "a"); err != ErrBucketNotFound {
+		t.Fatal(err)
+	}
+	if be.Calls != 1 {
+		t.Fatal(be.Calls)
+	}
+}
+
+func TestRetryNotRewindable(t *testing.T) {
+	cl, be := newFlakyClientForTesting(1, syscall.ECONNRESET)
+
+	// A partially consumed stream can't be retried.
+	err := cl.Put(bytes.NewBufferString("Some data."), testBucket, "a")
+	if err != syscall.ECONNRESET {
+		t.Fatal(err)
+	}
+	if be.Calls != 1 {
+		t.Fatal(be.Calls)
+	}
+}
+
+// truncatedReader is a seekable reader that fails with io.ErrUnexpectedEOF
+// once n bytes were read from it in total, like a file truncated while it's
+// uploaded.
+type truncatedReader struct {
+	r *bytes.Reader
+	n *int
+}
+
+func (r truncatedReader) Read(p []byte) (int, error) {
+	if *r.n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > *r.n {
+		p = p[:*r.n]
+	}
+	n, err := r.r.Read(p)
+	*r.n -= n
+	return n, err
+}
+
+func (r truncatedReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > *r.n {
+		n, _ := r.r.ReadAt(p[:*r.n], off)
+		*r.n -= n
+		return n, io.ErrUnexpectedEOF
+	}
+	n, err := r.r.ReadAt(p, off)
+	*r.n -= n
+	return n, err
+}
+
+func (r truncatedReader) Seek(offset int64, whence int) (int64, error) {
+	return r.r.Seek(offset, whence)
+}
+
+func TestRetryReaderError(t *testing.T) {
+	cl, be := newFlakyClientForTesting(0, nil)
+
+	// Errors of the caller's reader aren't retried, also if they'd be
+	// transient when reading from the backend. The checksum is computed
+	// before uploading, so the data is truncated during the upload.
+	data := []byte("Some data.")
+	for _, opts := range []PutOptions{{}, {Codec: CodecGzip}} {
+		be.Calls = 0
+		n := len(data) + 5
+		r := truncatedReader{bytes.NewReader(data), &n}
+		err := cl.PutObject(context.Background(), r, testBucket, "a", opts)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatal(opts.Codec, err)
+		}
+		if be.Calls != 1 {
+			t.Fatal(opts.Codec, be.Calls)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	type TestCase struct {
+		Err       error
+		Transient bool
+	}
+
+	cases := []TestCase{
+		{nil, false},
+		{ErrPathNotFound, false},
+		{context.Canceled, false},
+		{io.ErrUnexpectedEOF, true},
+		{readerError{io.ErrUnexpectedEOF}, false},
+		{syscall.ECONNRESET, true},
+		{&MultiError{Errs: map[string]error{"a": syscall.ECONNRESET}}, true},
+		{&MultiError{Errs: map[string]error{
+			"a": syscall.ECONNRESET,
+			"b": ErrPathNotFound,
+		}}, false},
+	}
+
+	for _, tc := range cases {
+		if IsTransient(tc.Err) != tc.Transient {
+			t.Fatal(tc.Err)
+		}
+	}
+}
+
+func TestRetryDeleteFailed(t *testing.T) {
+	cl, be := newFlakyClientForTesting(1, syscall.ECONNRESET)
+
+	for _, rPath := range []string{"a", "b", "c"} {
+		if err := be.Backend.Put(context.Background(), testBucket, rPath,
+			bytes.NewBufferString(rPath), BackendPutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the object that failed is retried.
+	if err := cl.Delete(testBucket, "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if len(be.Deletes) != 2 || len(be.Deletes[0]) != 3 ||
+		len(be.Deletes[1]) != 1 || be.Deletes[1][0] != "a" {
+		t.Fatal(be.Deletes)
+	}
+
+	l, err := cl.List(testBucket, "", true)
+	if err != nil || len(l) != 0 {
+		t.Fatal(l, err)
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	pathErr := &os.PathError{Op: "remove", Path: "b", Err: syscall.EACCES}
+	var err error = &MultiError{Errs: map[string]error{
+		"a": ErrBucketNotFound,
+		"b": pathErr,
+	}}
+
+	if !errors.Is(err, ErrBucketNotFound) || !errors.Is(err, syscall.EACCES) {
+		t.Fatal(err)
+	}
+	if errors.Is(err, ErrPathNotFound) {
+		t.Fatal(err)
+	}
+
+	var asErr *os.PathError
+	if !errors.As(err, &asErr) || asErr != pathErr {
+		t.Fatal(asErr)
+	}
+
+	var multiErr *MultiError
+	if !errors.As(fmt.Errorf("delete: %w", err), &multiErr) {
+		t.Fatal(err)
+	}
+	if keys := multiErr.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatal(keys)
+	}
+	if err.Error() != "2 objects failed: a: BucketNotFound; b: remove b: permission denied" {
+		t.Fatal(err.Error())
+	}
+
+	if newMultiError(map[string]error{}) != nil {
+		t.Fatal("Empty MultiError.")
+	}
+}
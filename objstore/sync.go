@@ -0,0 +1,441 @@
+package objstore
+
+import (
+	"context"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SyncCompare selects how SyncUp and SyncDown decide whether a file and its
+// object differ.
+type SyncCompare int
+
+const (
+	// SyncChecksum compares the SHA-256 of local files with the checksum
+	// recorded with their objects. Local files are read, objects aren't.
+	SyncChecksum SyncCompare = iota
+
+	// SyncModTime compares the size and modification time, to the second,
+	// of local files with those recorded by PutObjectFile. Nothing is read,
+	// except for objects written otherwise, which are compared by checksum.
+	SyncModTime
+)
+
+// SyncOptions configures SyncUp and SyncDown.
+type SyncOptions struct {
+	Put PutOptions // How SyncUp writes objects.
+	Get GetOptions // How SyncDown reads objects.
+
+	// Include and Exclude select the files to sync, see PutDirOptions. Files
+	// and objects that aren't selected are neither copied nor deleted.
+	Include []string
+	Exclude []string
+
+	Compare SyncCompare
+	Delete  bool // Delete what's missing from the source at the destination.
+	DryRun  bool // Report what would be done without writing anything.
+	Workers int  // Number of files processed concurrently. Default: 8.
+}
+
+// SyncReport lists the files processed by SyncUp or SyncDown by outcome, as
+// slash-separated paths relative to the directory and prefix. In a dry run,
+// it lists what would have been done.
+type SyncReport struct {
+	Copied    []string         // Missing or different at the destination.
+	Deleted   []string         // Missing from the source.
+	Unchanged []string         // The same at the source and destination.
+	Failed    map[string]error // Files that couldn't be processed.
+}
+
+// Err returns a *MultiError for the failed files, or nil if none failed.
+func (r SyncReport) Err() error {
+	return newMultiError(r.Failed)
+}
+
+type syncAction int
+
+const (
+	syncNone syncAction = iota
+	syncUnchanged
+	syncCopy
+	syncDelete
+)
+
+// ----------------------------------------------------------------------------
+
+// SyncUp mirrors the regular files below lPath to objects below prefix in
+// bucket, writing only the files whose objects are missing or differ. The
+// relative path of each file is appended to prefix, with a slash in between.
+//
+// The returned error is only set if the files or objects couldn't be listed.
+// Errors for individual files are returned in the report.
+func (cl *Client) SyncUp(
+	lPath,
+	bucket,
+	prefix string,
+	opts SyncOptions,
+) (
+	SyncReport,
+	error,
+) {
+	return cl.SyncUpContext(context.Background(), lPath, bucket, prefix, opts)
+}
+
+func (cl *Client) SyncUpContext(
+	ctx context.Context,
+	lPath,
+	bucket,
+	prefix string,
+	opts SyncOptions,
+) (
+	SyncReport,
+	error,
+) {
+	prefix = syncPrefix(prefix)
+
+	// A missing directory would otherwise delete all objects.
+	if _, err := os.Stat(lPath); err != nil {
+		log.Printf("Failed to stat %s: %v", lPath, err)
+		return SyncReport{}, err
+	}
+
+	files, objects, err := cl.syncList(ctx, lPath, bucket, prefix, opts)
+	if err != nil {
+		return SyncReport{}, err
+	}
+
+	return syncEach(files, objects, opts, func(name string) (syncAction, error) {
+		rPath := prefix + name
+		file, ok := files[name]
+
+		if !ok {
+			if !opts.Delete {
+				return syncNone, nil
+			}
+			if opts.DryRun {
+				return syncDelete, nil
+			}
+			return syncDelete, cl.DeleteContext(ctx, bucket, rPath)
+		}
+
+		fPath := filepath.Join(lPath, filepath.FromSlash(name))
+
+		if obj, ok := objects[name]; ok {
+			same, err := syncSame(fPath, file, obj, opts.Compare)
+			if err != nil || same {
+				return syncUnchanged, err
+			}
+		}
+
+		if opts.DryRun {
+			return syncCopy, nil
+		}
+		return syncCopy, cl.PutObjectFile(ctx, fPath, bucket, rPath, opts.Put)
+	}), nil
+}
+
+// SyncDown mirrors the objects below prefix in bucket to files below lPath,
+// like SyncUp in the other direction. Files get the modification time
+// recorded by PutObjectFile, if any. Directories are created as needed, but
+// not deleted.
+func (cl *Client) SyncDown(
+	bucket,
+	prefix,
+	lPath string,
+	opts SyncOptions,
+) (
+	SyncReport,
+	error,
+) {
+	return cl.SyncDownContext(context.Background(), bucket, prefix, lPath, opts)
+}
+
+func (cl *Client) SyncDownContext(
+	ctx context.Context,
+	bucket,
+	prefix,
+	lPath string,
+	opts SyncOptions,
+) (
+	SyncReport,
+	error,
+) {
+	prefix = syncPrefix(prefix)
+
+	files, objects, err := cl.syncList(ctx, lPath, bucket, prefix, opts)
+	if err != nil {
+		return SyncReport{}, err
+	}
+
+	return syncEach(files, objects, opts, func(name string) (syncAction, error) {
+		obj, ok := objects[name]
+
+		fPath, err := syncFilePath(lPath, name)
+		if err != nil {
+			log.Printf("Refusing to write %s outside of %s", name, lPath)
+			return syncCopy, err
+		}
+
+		if !ok {
+			if !opts.Delete {
+				return syncNone, nil
+			}
+			if opts.DryRun {
+				return syncDelete, nil
+			}
+			if err := os.Remove(fPath); err != nil {
+				log.Printf("Failed to remove file %s: %v", fPath, err)
+				return syncDelete, err
+			}
+			return syncDelete, nil
+		}
+
+		if file, ok := files[name]; ok {
+			same, err := syncSame(fPath, file, obj, opts.Compare)
+			if err != nil || same {
+				return syncUnchanged, err
+			}
+		}
+
+		if opts.DryRun {
+			return syncCopy, nil
+		}
+		return syncCopy, cl.syncDownFile(ctx, bucket, prefix+name, fPath, obj, opts)
+	}), nil
+}
+
+// syncFilePath returns the path of the file for the object name below lPath.
+// Object keys can hold anything, so names that could refer to a file outside
+// of lPath, or to lPath itself, give errInvalidPath.
+func syncFilePath(lPath, name string) (string, error) {
+	if strings.Contains(name, "\\") {
+		return "", errInvalidPath
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", errInvalidPath
+		}
+	}
+
+	fPath := filepath.Join(lPath, filepath.FromSlash(name))
+	rel, err := filepath.Rel(lPath, fPath)
+	if err != nil || rel == "." || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errInvalidPath
+	}
+	return fPath, nil
+}
+
+func (cl *Client) syncDownFile(
+	ctx context.Context,
+	bucket,
+	rPath,
+	fPath string,
+	obj FileInfo,
+	opts SyncOptions,
+) error {
+	if err := os.MkdirAll(filepath.Dir(fPath), 0700); err != nil {
+		log.Printf("Failed to create directory for %s: %v", fPath, err)
+		return err
+	}
+
+	if err := cl.GetObjectFile(ctx, bucket, rPath, fPath, opts.Get); err != nil {
+		return err
+	}
+
+	if obj.FileModTime.IsZero() {
+		return nil
+	}
+	if err := os.Chtimes(fPath, obj.FileModTime, obj.FileModTime); err != nil {
+		log.Printf("Failed to set modification time of %s: %v", fPath, err)
+		return err
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// syncPrefix returns prefix as a directory, ending in a slash unless empty.
+func syncPrefix(prefix string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// syncList returns the selected regular files below lPath and objects below
+// prefix, by relative path. A missing lPath has no files.
+func (cl *Client) syncList(
+	ctx context.Context,
+	lPath,
+	bucket,
+	prefix string,
+	opts SyncOptions,
+) (
+	files map[string]os.FileInfo,
+	objects map[string]FileInfo,
+	err error,
+) {
+	if err := checkPatterns(opts.Include, opts.Exclude); err != nil {
+		return nil, nil, err
+	}
+
+	files = map[string]os.FileInfo{}
+	if _, err := os.Stat(lPath); err == nil {
+		entries, err := dirEntries(lPath, PutDirOptions{
+			Include: opts.Include,
+			Exclude: opts.Exclude,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, e := range entries {
+			if e.info.Mode().IsRegular() {
+				files[e.name] = e.info
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Failed to stat %s: %v", lPath, err)
+		return nil, nil, err
+	}
+
+	l, err := cl.ListMetaContext(ctx, bucket, prefix, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects = map[string]FileInfo{}
+	for _, info := range l {
+		// Keys ending in a slash are folder markers, as created by the S3
+		// console and other tools, rather than files.
+		if strings.HasSuffix(info.Name, "/") {
+			continue
+		}
+		name := strings.TrimPrefix(info.Name, prefix)
+		if syncSelected(name, opts) {
+			objects[name] = info
+		}
+	}
+
+	return files, objects, nil
+}
+
+// syncSelected reports whether the object with the relative path name is
+// selected by the include and exclude patterns, the same way as dirEntries
+// selects files.
+func syncSelected(name string, opts SyncOptions) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if matchAny(opts.Exclude, dir) {
+			return false
+		}
+	}
+	if matchAny(opts.Exclude, name) {
+		return false
+	}
+	return len(opts.Include) == 0 || matchAny(opts.Include, name)
+}
+
+// syncSame reports whether the local file fPath with the given information
+// is the same as the object with the given information.
+func syncSame(
+	fPath string,
+	file os.FileInfo,
+	obj FileInfo,
+	compare SyncCompare,
+) (
+	bool,
+	error,
+) {
+	_, sizeRecorded := obj.Meta[metaFileSize]
+	if sizeRecorded && obj.FileSize != file.Size() {
+		return false, nil
+	}
+
+	if compare == SyncModTime && sizeRecorded {
+		return obj.FileModTime.Unix() == file.ModTime().Unix(), nil
+	}
+
+	if obj.Checksum == "" {
+		return false, nil
+	}
+
+	f, err := os.Open(fPath)
+	if err != nil {
+		log.Printf("Failed to open file %s: %v", fPath, err)
+		return false, err
+	}
+	defer f.Close()
+
+	sum, err := seekableChecksum(f)
+	return sum == obj.Checksum, err
+}
+
+// syncEach calls fn for each file and object name with opts.Workers
+// goroutines, and collects the outcomes in a report.
+func syncEach(
+	files map[string]os.FileInfo,
+	objects map[string]FileInfo,
+	opts SyncOptions,
+	fn func(name string) (syncAction, error),
+) SyncReport {
+	report := SyncReport{
+		Failed: map[string]error{},
+	}
+
+	nameCh := make(chan string, len(files)+len(objects))
+	for name := range files {
+		nameCh <- name
+	}
+	for name := range objects {
+		if _, ok := files[name]; !ok {
+			nameCh <- name
+		}
+	}
+	close(nameCh)
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 8
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range nameCh {
+				action, err := fn(name)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					report.Failed[name] = err
+				case action == syncUnchanged:
+					report.Unchanged = append(report.Unchanged, name)
+				case action == syncCopy:
+					report.Copied = append(report.Copied, name)
+				case action == syncDelete:
+					report.Deleted = append(report.Deleted, name)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	sort.Strings(report.Copied)
+	sort.Strings(report.Deleted)
+	sort.Strings(report.Unchanged)
+
+	return report
+}
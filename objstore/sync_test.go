@@ -0,0 +1,196 @@
+package objstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientSync(t *testing.T) {
+	cl := NewClientForTesting()
+
+	mtime := makeTestTree(t, "files/out/src")
+
+	check := func(report SyncReport, err error, copied, deleted, unchanged string) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Failed) > 0 {
+			t.Fatal(report.Failed)
+		}
+		if strings.Join(report.Copied, ",") != copied ||
+			strings.Join(report.Deleted, ",") != deleted ||
+			strings.Join(report.Unchanged, ",") != unchanged {
+			t.Fatal(report)
+		}
+	}
+
+	report, err := cl.SyncUp("files/out/src", testBucket, "sync", SyncOptions{})
+	check(report, err, "a/b/file.txt,top.log", "", "")
+
+	report, err = cl.SyncUp("files/out/src", testBucket, "sync", SyncOptions{})
+	check(report, err, "", "", "a/b/file.txt,top.log")
+
+	info, err := cl.Stat(testBucket, "sync/a/b/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.FileSize != 4 || !info.FileModTime.Equal(mtime) {
+		t.Fatal(info.FileSize, info.FileModTime)
+	}
+
+	// Changed contents with the same size and modification time are only
+	// found by comparing checksums.
+	if err := ioutil.WriteFile("files/out/src/top.log", []byte("LOG"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes("files/out/src/top.log", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = cl.SyncUp("files/out/src", testBucket, "sync", SyncOptions{Compare: SyncModTime})
+	check(report, err, "", "", "a/b/file.txt,top.log")
+
+	report, err = cl.SyncUp("files/out/src", testBucket, "sync", SyncOptions{DryRun: true})
+	check(report, err, "top.log", "", "a/b/file.txt")
+
+	report, err = cl.SyncUp("files/out/src", testBucket, "sync", SyncOptions{})
+	check(report, err, "top.log", "", "a/b/file.txt")
+
+	// Extras are only deleted if asked to, and unless excluded.
+	if err := cl.PutBytes([]byte("x"), testBucket, "sync/extra"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PutBytes([]byte("x"), testBucket, "sync/a/extra.log"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = cl.SyncUp("files/out/src", testBucket, "sync", SyncOptions{})
+	check(report, err, "", "", "a/b/file.txt,top.log")
+
+	opts := SyncOptions{Delete: true, DryRun: true, Exclude: []string{"*.log"}}
+	report, err = cl.SyncUp("files/out/src", testBucket, "sync", opts)
+	check(report, err, "", "extra", "a/b/file.txt")
+
+	opts.DryRun = false
+	report, err = cl.SyncUp("files/out/src", testBucket, "sync", opts)
+	check(report, err, "", "extra", "a/b/file.txt")
+
+	if _, err := cl.Stat(testBucket, "sync/extra"); err != ErrPathNotFound {
+		t.Fatal(err)
+	}
+	if _, err := cl.Stat(testBucket, "sync/a/extra.log"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing source directory isn't taken as empty.
+	if _, err := cl.SyncUp("files/out/missing", testBucket, "sync", opts); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	// Down, the modification times are restored.
+	report, err = cl.SyncDown(testBucket, "sync/", "files/out/dst", SyncOptions{})
+	check(report, err, "a/b/file.txt,a/extra.log,top.log", "", "")
+
+	if !pathsMatch("files/out/src/top.log", "files/out/dst/top.log") {
+		t.Fatal("top.log")
+	}
+	fi, err := os.Stat("files/out/dst/a/b/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatal(fi.ModTime())
+	}
+
+	report, err = cl.SyncDown(testBucket, "sync", "files/out/dst", SyncOptions{Compare: SyncModTime})
+	check(report, err, "", "", "a/b/file.txt,a/extra.log,top.log")
+
+	if err := ioutil.WriteFile("files/out/dst/local", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes("files/out/dst/top.log", time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	opts = SyncOptions{Compare: SyncModTime, Delete: true}
+	report, err = cl.SyncDown(testBucket, "sync", "files/out/dst", opts)
+	check(report, err, "top.log", "local", "a/b/file.txt,a/extra.log")
+
+	if _, err := os.Stat("files/out/dst/local"); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	// Folder markers are skipped.
+	cl.Backend = folderBackend{cl.Backend}
+	report, err = cl.SyncDown(testBucket, "sync/", "files/out/dst", opts)
+	check(report, err, "", "", "a/b/file.txt,a/extra.log,top.log")
+}
+
+// folderBackend lists zero-byte folder markers, as created by the S3 console,
+// for the listed prefix and its subdirectory "a".
+type folderBackend struct {
+	Backend
+}
+
+func (be folderBackend) List(
+	ctx context.Context,
+	bucket,
+	prefix string,
+	opts BackendListOptions,
+) (
+	[]FileInfo,
+	bool,
+	error,
+) {
+	l, truncated, err := be.Backend.List(ctx, bucket, prefix, opts)
+	if err == nil && opts.StartAfter == "" {
+		l = append([]FileInfo{{Name: prefix}, {Name: prefix + "a/"}}, l...)
+	}
+	return l, truncated, err
+}
+
+func (be folderBackend) Stat(ctx context.Context, bucket, rPath string) (FileInfo, error) {
+	if strings.HasSuffix(rPath, "/") {
+		return FileInfo{Name: rPath}, nil
+	}
+	return be.Backend.Stat(ctx, bucket, rPath)
+}
+
+func TestSyncFilePath(t *testing.T) {
+	type TestCase struct {
+		Name  string
+		Valid bool
+	}
+
+	cases := []TestCase{
+		{"a", true},
+		{"a/b.txt", true},
+		{"..a/b", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../a", false},
+		{"a/../../b", false},
+		{"a/..", false},
+		{"/etc/passwd", false},
+		{"a//b", false},
+		{"a/", false},
+		{"a\\..\\..\\b", false},
+	}
+
+	for _, tc := range cases {
+		fPath, err := syncFilePath("files/out/dst", tc.Name)
+		if (err == nil) != tc.Valid {
+			t.Fatal(tc.Name, err)
+		}
+		if tc.Valid && fPath != filepath.Join("files/out/dst", filepath.FromSlash(tc.Name)) {
+			t.Fatal(tc.Name, fPath)
+		}
+	}
+}
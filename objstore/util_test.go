@@ -8,9 +8,13 @@ import (
 )
 
 var (
-	testBucket = "suburbia-test"
+	testBucket    = "suburbia-test"
+	testLocalRoot = "files/store"
+	testEncKey    = []byte("0123456789abcdef0123456789abcdef")
 )
 
+// NewClientForTesting returns a client for the object store configured in
+// the environment. If SB_OBJSTORE_HOST isn't set, a local backend is used.
 func NewClientForTesting() *Client {
 	cl := &Client{
 		Host:   os.Getenv("SB_OBJSTORE_HOST"),
@@ -18,7 +22,13 @@ func NewClientForTesting() *Client {
 		Secret: os.Getenv("SB_OBJSTORE_SECRET"),
 		EncKey: []byte(os.Getenv("SB_OBJSTORE_ENC_KEY")),
 	}
-	if err := cl.Connect(); err != nil {
+	if cl.Host == "" {
+		if err := os.MkdirAll(filepath.Join(testLocalRoot, testBucket), 0700); err != nil {
+			panic(err)
+		}
+		cl.Backend = NewLocalBackend(testLocalRoot)
+		cl.EncKey = testEncKey
+	} else if err := cl.Connect(); err != nil {
 		panic(err)
 	}
 	cl.TestCleanup()
@@ -26,15 +36,11 @@ func NewClientForTesting() *Client {
 }
 
 func (cl *Client) TestCleanup() {
-	l, err := cl.List(testBucket, "", true)
+	report, err := cl.DeletePrefix(testBucket, "", PrefixOptions{})
 	if err != nil {
 		panic(err)
 	}
-	delPaths := make([]string, len(l))
-	for i := range l {
-		delPaths[i] = l[i].Name
-	}
-	if err := cl.Delete(testBucket, delPaths...); err != nil {
+	if err := report.Err(); err != nil {
 		panic(err)
 	}
 	if err := os.RemoveAll("files/out"); err != nil {